@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// AggregationConfig controls how quotes from several providers are merged.
+type AggregationConfig struct {
+	Quorum    int     // minimum number of agreeing sources required to keep a coin.
+	Tolerance float64 // max percent deviation from the median price before a source is dropped.
+	IDSource  string  // provider whose coin IDs the catalog uses, the first configured one by default.
+}
+
+var aggregation = AggregationConfig{Quorum: 1, Tolerance: 5.0}
+
+const (
+	QUORUM_ENV    = "PRICE_QUORUM"
+	TOLERANCE_ENV = "PRICE_TOLERANCE"
+	ID_SOURCE_ENV = "CATALOG_ID_SOURCE"
+)
+
+func configureAggregation() {
+	if v := os.Getenv(QUORUM_ENV); v != "" {
+		quorum, err := strconv.Atoi(v)
+		if err != nil || quorum < 1 {
+			log.Errorf("invalid %s: %s", QUORUM_ENV, v)
+		} else {
+			aggregation.Quorum = quorum
+		}
+	}
+	if v := os.Getenv(TOLERANCE_ENV); v != "" {
+		tolerance, err := strconv.ParseFloat(v, 64)
+		if err != nil || tolerance <= 0 {
+			log.Errorf("invalid %s: %s", TOLERANCE_ENV, v)
+		} else {
+			aggregation.Tolerance = tolerance
+		}
+	}
+	aggregation.IDSource = os.Getenv(ID_SOURCE_ENV)
+	if aggregation.IDSource == "" && len(priceProviders) > 0 {
+		aggregation.IDSource = priceProviders[0].Name()
+	}
+	log.Debugf("price aggregation quorum=%d tolerance=%.2f%% ids from %s",
+		aggregation.Quorum, aggregation.Tolerance, aggregation.IDSource)
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// fetchAllPrices queries every configured provider concurrently and returns
+// the successful responses and the failures, both keyed by provider name.
+func fetchAllPrices(providers []PriceProvider) (map[string]map[string]CoinInfo, map[string]error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sourcePrices := make(map[string]map[string]CoinInfo)
+	failures := make(map[string]error)
+
+	for _, provider := range providers {
+		wg.Add(1)
+		go func(provider PriceProvider) {
+			defer wg.Done()
+			prices, err := provider.FetchPrices()
+			if err != nil {
+				log.Errorf("price provider %s failed: %s", provider.Name(), err.Error())
+				mu.Lock()
+				failures[provider.Name()] = err
+				mu.Unlock()
+				return
+			}
+			log.Debug("found coininfo for ", len(prices), " coins from ", provider.Name())
+			mu.Lock()
+			sourcePrices[provider.Name()] = prices
+			mu.Unlock()
+		}(provider)
+	}
+	wg.Wait()
+	return sourcePrices, failures
+}
+
+type sourceQuote struct {
+	source string
+	price  float64
+	info   CoinInfo
+}
+
+// aggregatePrices consolidates per-source quotes into one CoinInfo per coin
+// key. Sources whose USD price deviates from the median by more than the
+// tolerance are dropped, and coins with fewer than quorum remaining sources
+// are left out entirely.
+func aggregatePrices(sourcePrices map[string]map[string]CoinInfo, config AggregationConfig) map[string]CoinInfo {
+	quotes := make(map[string][]sourceQuote)
+	for source, prices := range sourcePrices {
+		for key, info := range prices {
+			price, err := strconv.ParseFloat(info.PriceUSD, 64)
+			if err != nil || price <= 0 {
+				continue
+			}
+			quotes[key] = append(quotes[key], sourceQuote{source, price, info})
+		}
+	}
+
+	var CoinDeltas = make(map[string]CoinInfo)
+	for key, qs := range quotes {
+		var prices []float64
+		for _, q := range qs {
+			prices = append(prices, q.price)
+		}
+		mid := median(prices)
+
+		var kept []sourceQuote
+		for _, q := range qs {
+			deviation := math.Abs(q.price-mid) / mid * 100
+			if deviation > config.Tolerance {
+				log.Errorf("dropping %s quote for %s: price %f deviates %.2f%% from median %f",
+					q.source, key, q.price, deviation, mid)
+				continue
+			}
+			kept = append(kept, q)
+		}
+
+		if len(kept) < config.Quorum {
+			log.Errorf("skipping %s: only %d of %d sources agree (quorum %d)",
+				key, len(kept), len(qs), config.Quorum)
+			continue
+		}
+		merged := mergeQuotes(kept)
+		merged.ID = pinnedID(qs, config.IDSource, merged.ID)
+		CoinDeltas[key] = merged
+	}
+	return CoinDeltas
+}
+
+// pinnedID returns the coin ID reported by source, or fallback when that
+// source did not quote the coin. Outliers still count, since the ID says
+// which coin it is rather than what it costs.
+func pinnedID(qs []sourceQuote, source string, fallback string) string {
+	for _, q := range qs {
+		if q.source == source && q.info.ID != "" {
+			return q.info.ID
+		}
+	}
+	return fallback
+}
+
+// mergeQuotes builds a consolidated CoinInfo using the median of each numeric
+// field reported by the given sources.
+func mergeQuotes(qs []sourceQuote) CoinInfo {
+	sort.Slice(qs, func(i, j int) bool { return qs[i].source < qs[j].source })
+
+	merged := qs[0].info
+	merged.Sources = nil
+	var infos []CoinInfo
+	var lastUpdated int64
+	for _, q := range qs {
+		infos = append(infos, q.info)
+		merged.Sources = append(merged.Sources, q.source)
+		if t, err := strconv.ParseInt(q.info.LastUpdated, 10, 64); err == nil && t > lastUpdated {
+			lastUpdated = t
+			merged.LastUpdated = q.info.LastUpdated
+		}
+	}
+
+	merged.PriceUSD = medianField(infos, func(c CoinInfo) string { return c.PriceUSD })
+	merged.PriceBTC = medianField(infos, func(c CoinInfo) string { return c.PriceBTC })
+	merged.Volume24 = medianField(infos, func(c CoinInfo) string { return c.Volume24 })
+	merged.MarketCapUSD = medianField(infos, func(c CoinInfo) string { return c.MarketCapUSD })
+	merged.Change1h = medianField(infos, func(c CoinInfo) string { return c.Change1h })
+	merged.Change24h = medianField(infos, func(c CoinInfo) string { return c.Change24h })
+	merged.Change7d = medianField(infos, func(c CoinInfo) string { return c.Change7d })
+	return merged
+}
+
+func medianField(infos []CoinInfo, field func(CoinInfo) string) string {
+	var values []float64
+	for _, info := range infos {
+		v, err := strconv.ParseFloat(field(info), 64)
+		if err == nil {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return formatFloat(median(values))
+}
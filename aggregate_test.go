@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMedian(t *testing.T) {
+	assert.Equal(t, 2.0, median([]float64{3, 1, 2}))
+	assert.Equal(t, 2.5, median([]float64{4, 1, 3, 2}))
+	assert.Equal(t, 0.0, median(nil))
+}
+
+func TestAggregatePricesDropsOutliers(t *testing.T) {
+	sourcePrices := map[string]map[string]CoinInfo{
+		"a": {"BTC_BITCOIN": {Symbol: "BTC", Name: "Bitcoin", PriceUSD: "100", Change1h: "1"}},
+		"b": {"BTC_BITCOIN": {Symbol: "BTC", Name: "Bitcoin", PriceUSD: "102", Change1h: "3"}},
+		"c": {"BTC_BITCOIN": {Symbol: "BTC", Name: "Bitcoin", PriceUSD: "500", Change1h: "400"}},
+	}
+
+	prices := aggregatePrices(sourcePrices, AggregationConfig{Quorum: 2, Tolerance: 5})
+	btc := prices["BTC_BITCOIN"]
+	assert.Equal(t, "101", btc.PriceUSD)
+	assert.Equal(t, "2", btc.Change1h)
+	assert.Equal(t, []string{"a", "b"}, btc.Sources)
+}
+
+func TestAggregatePricesQuorum(t *testing.T) {
+	sourcePrices := map[string]map[string]CoinInfo{
+		"a": {"BTC_BITCOIN": {PriceUSD: "100"}, "ETH_ETHEREUM": {PriceUSD: "10"}},
+		"b": {"BTC_BITCOIN": {PriceUSD: "101"}},
+	}
+
+	prices := aggregatePrices(sourcePrices, AggregationConfig{Quorum: 2, Tolerance: 5})
+	assert.Contains(t, prices, "BTC_BITCOIN")
+	assert.NotContains(t, prices, "ETH_ETHEREUM")
+}
+
+func TestAggregatePricesPinsIDSource(t *testing.T) {
+	sourcePrices := map[string]map[string]CoinInfo{
+		"cmc":      {"BTC_BITCOIN": {ID: "btc", PriceUSD: "100"}},
+		"exchange": {"BTC_BITCOIN": {ID: "bitcoin-spot", PriceUSD: "101"}},
+		"gecko":    {"BTC_BITCOIN": {ID: "bitcoin", PriceUSD: "500"}},
+	}
+	// The pinned source's ID wins even when its price is dropped as an outlier.
+	prices := aggregatePrices(sourcePrices, AggregationConfig{Quorum: 1, Tolerance: 5, IDSource: "gecko"})
+	assert.Equal(t, "bitcoin", prices["BTC_BITCOIN"].ID)
+
+	delete(sourcePrices, "gecko")
+	prices = aggregatePrices(sourcePrices, AggregationConfig{Quorum: 1, Tolerance: 5, IDSource: "gecko"})
+	assert.Equal(t, "btc", prices["BTC_BITCOIN"].ID)
+}
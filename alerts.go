@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alert kinds. An empty Kind is treated as a percent-change alert so rows
+// created before kinds existed keep working.
+const (
+	ALERT_KIND_CHANGE = "change"
+	ALERT_KIND_PRICE  = "price"
+)
+
+// Price alert directions.
+const (
+	DIRECTION_ABOVE = "above"
+	DIRECTION_BELOW = "below"
+)
+
+var errStaleQuote = errors.New("stale quote")
+
+// evaluation holds the data shared by all alerts in one runCoinTask pass.
+type evaluation struct {
+	CoinDeltas   map[string]CoinInfo
+	CoinKeys     map[string]string // catalog ID to CoinDeltas key.
+	Now          time.Time
+	Listings     []ListingEvent // listing changes detected on this tick.
+	FX           FXRates
+	Holdings     map[string][]Holding // by user email.
+	Market       MarketSnapshot
+	SourcePrices map[string]map[string]CoinInfo // each provider's quotes before aggregation.
+}
+
+// reading is what an alert observed on one tick.
+type reading struct {
+	Notification Notification // sent if the alert fires.
+	Value        float64
+	Violation    bool
+	Rearmed      bool // the value has retreated past the hysteresis band.
+}
+
+// alertKind validates alerts of one kind at creation time and evaluates
+// them against the fetched prices.
+type alertKind struct {
+	validate func(alert *Alert) error
+	evaluate func(alert Alert, e evaluation) (reading, error)
+}
+
+var alertKinds = make(map[string]alertKind)
+
+func init() {
+	registerAlertKind(ALERT_KIND_CHANGE, alertKind{validateChangeAlert, evaluateChangeAlert})
+	registerAlertKind(ALERT_KIND_PRICE, alertKind{validatePriceAlert, evaluatePriceAlert})
+}
+
+func registerAlertKind(kind string, k alertKind) {
+	alertKinds[kind] = k
+}
+
+func alertKindOf(alert Alert) string {
+	if alert.Kind == "" {
+		return ALERT_KIND_CHANGE
+	}
+	return alert.Kind
+}
+
+// validateAlert normalizes and checks an alert before it is stored.
+func validateAlert(alert *Alert) error {
+	alert.Kind = alertKindOf(*alert)
+	alert.Triggered = false
+	alert.LastEvaluatedAt = nil
+	if alert.Hysteresis < 0 {
+		return errors.New("hysteresis must not be negative")
+	}
+	k, ok := alertKinds[alert.Kind]
+	if !ok {
+		return fmt.Errorf("unknown alert kind %q", alert.Kind)
+	}
+	return k.validate(alert)
+}
+
+// evaluateAlert checks a single alert and fills in the notification fields
+// common to all kinds.
+func evaluateAlert(alert Alert, e evaluation) (reading, error) {
+	k, ok := alertKinds[alertKindOf(alert)]
+	if !ok {
+		return reading{}, fmt.Errorf("unknown alert kind %q", alert.Kind)
+	}
+	r, err := k.evaluate(alert, e)
+	if err != nil {
+		return r, err
+	}
+	r.Notification.AlertId = alert.ID
+	r.Notification.Email = alert.Email
+	r.Notification.Kind = alertKindOf(alert)
+	return r, nil
+}
+
+// advanceAlertState applies a reading to the alert's arm/disarm state and
+// reports whether the alert should fire. An armed alert fires on the tick it
+// enters violation and is then disarmed until the reading re-arms it. Event
+// readings may re-arm and fire on the same tick.
+func advanceAlertState(alert *Alert, r reading, now time.Time) bool {
+	fire := false
+	if alert.Triggered && r.Rearmed {
+		log.Debugf("Re-arming alert ID(%d) at value %f", alert.ID, r.Value)
+		alert.Triggered = false
+	}
+	if !alert.Triggered && r.Violation {
+		fire = true
+		alert.Triggered = true
+	}
+	alert.LastValue = r.Value
+	alert.LastEvaluatedAt = &now
+	return fire
+}
+
+// alertState is the columns advanceAlertState changes.
+func alertState(alert Alert) map[string]interface{} {
+	return map[string]interface{}{
+		"triggered": alert.Triggered, "last_value": alert.LastValue, "last_evaluated_at": alert.LastEvaluatedAt,
+	}
+}
+
+func saveAlertState(alert Alert) {
+	err := db.Model(&alert).UpdateColumns(alertState(alert)).Error
+	if err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// levelReading builds a reading for a value compared against a fixed level.
+// The alert re-arms once the value is back on the other side of the level
+// by at least the hysteresis band.
+func levelReading(value float64, level float64, direction string, hysteresis float64) reading {
+	r := reading{Value: value, Violation: isLevelCrossed(value, level, direction)}
+	switch direction {
+	case DIRECTION_ABOVE:
+		r.Rearmed = value <= level-hysteresis
+	case DIRECTION_BELOW:
+		r.Rearmed = value >= level+hysteresis
+	}
+	return r
+}
+
+// lookupCoin returns the fetched quote for the alert's coin, or
+// errStaleQuote when it is missing or too old to act on.
+func lookupCoin(alert Alert, e evaluation) (string, CoinInfo, error) {
+	return lookupCoinQuote(alert, alert.CoinID, alert.CoinSymbol, alert.CoinName, e)
+}
+
+// lookupCoinQuote finds a coin by catalog ID when the alert has one, so
+// renames do not break it, and by symbol and name otherwise.
+func lookupCoinQuote(alert Alert, coinID string, coinSymbol string, coinName string, e evaluation) (string, CoinInfo, error) {
+	coinMapKey, ok := e.CoinKeys[coinID]
+	if !ok {
+		coinMapKey = createCoinKey(coinSymbol, coinName)
+	}
+	coinInfo, ok := e.CoinDeltas[coinMapKey]
+	if !ok {
+		log.Error("Could not find coin with key", coinMapKey, " in api response map")
+		return coinMapKey, coinInfo, errStaleQuote
+	}
+	if isStaleQuote(coinInfo, fetchPolicy.MaxQuoteAge, e.Now) {
+		log.Errorf("Skipping alert %d: quote for %s is older than %s (last updated %s)",
+			alert.ID, coinMapKey, fetchPolicy.MaxQuoteAge, coinInfo.LastUpdated)
+		return coinMapKey, coinInfo, errStaleQuote
+	}
+	return coinMapKey, coinInfo, nil
+}
+
+// coinNotification starts a notification for a quote on a single coin.
+func coinNotification(coinInfo CoinInfo) Notification {
+	lastUpdated, err := strconv.ParseInt(coinInfo.LastUpdated, 10, 64)
+	if err != nil {
+		log.Error(err.Error())
+		lastUpdated = makeTimestamp()
+	}
+	return Notification{
+		CoinName: coinInfo.Name, CoinSymbol: coinInfo.Symbol,
+		LastUpdated: lastUpdated, Sources: strings.Join(coinInfo.Sources, ","),
+	}
+}
+
+func validateChangeAlert(alert *Alert) error {
+	if _, err := parseTimeDelta(alert.TimeDelta); err != nil {
+		return err
+	}
+	if alert.ThresholdDelta == 0 {
+		return errors.New("threshold_delta must be non-zero")
+	}
+	return nil
+}
+
+// thresholdDirection is the direction a signed percent threshold fires in.
+func thresholdDirection(threshold float64) string {
+	if threshold < 0 {
+		return DIRECTION_BELOW
+	}
+	return DIRECTION_ABOVE
+}
+
+func evaluateChangeAlert(alert Alert, e evaluation) (reading, error) {
+	coinMapKey, coinInfo, err := lookupCoin(alert, e)
+	if err != nil {
+		return reading{}, err
+	}
+
+	// Compute the change over the alert window.
+	change, err := coinChange(coinMapKey, coinInfo, alert.TimeDelta, e.Now)
+	if err != nil {
+		return reading{}, fmt.Errorf("could not compute %s change: %s", alert.TimeDelta, err.Error())
+	}
+
+	r := levelReading(change, alert.ThresholdDelta, thresholdDirection(alert.ThresholdDelta), alert.Hysteresis)
+	r.Violation = isViolation(change, alert.ThresholdDelta)
+	if r.Violation {
+		log.Debugf("Violation %s: (actual, threshold)=(%f, %f)",
+			alert.CoinSymbol, change, alert.ThresholdDelta)
+	}
+
+	r.Notification = coinNotification(coinInfo)
+	r.Notification.TimeDelta = alert.TimeDelta
+	r.Notification.CurrentDelta = change
+	r.Notification.ThresholdDelta = alert.ThresholdDelta
+	return r, nil
+}
+
+func validatePriceAlert(alert *Alert) error {
+	if alert.PriceLevel <= 0 {
+		return errors.New("price_level must be positive")
+	}
+	alert.Direction = strings.ToLower(alert.Direction)
+	if alert.Direction != DIRECTION_ABOVE && alert.Direction != DIRECTION_BELOW {
+		return fmt.Errorf("direction must be %q or %q", DIRECTION_ABOVE, DIRECTION_BELOW)
+	}
+	return validateQuoteCurrency(&alert.PriceCurrency, true)
+}
+
+// isLevelCrossed reports whether value is past level in the given direction.
+func isLevelCrossed(value float64, level float64, direction string) bool {
+	return (direction == DIRECTION_ABOVE && value > level) || (direction == DIRECTION_BELOW && value < level)
+}
+
+func evaluatePriceAlert(alert Alert, e evaluation) (reading, error) {
+	_, coinInfo, err := lookupCoin(alert, e)
+	if err != nil {
+		return reading{}, err
+	}
+
+	var metric, field string
+	if alert.PriceCurrency == "BTC" {
+		metric, field = "price_btc", coinInfo.PriceBTC
+	} else {
+		metric, field = "price_usd", coinInfo.PriceUSD
+	}
+	price, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return reading{}, fmt.Errorf("no %s quote for %s", metric, alert.CoinSymbol)
+	}
+	var rate float64
+	if alert.PriceCurrency != "BTC" {
+		if price, rate, err = e.FX.convertUSD(price, alert.PriceCurrency); err != nil {
+			return reading{}, err
+		}
+		metric = "price_" + strings.ToLower(alert.PriceCurrency)
+	}
+
+	r := levelReading(price, alert.PriceLevel, alert.Direction, alert.Hysteresis)
+	if r.Violation {
+		log.Debugf("Violation %s: price %f %s %f %s",
+			alert.CoinSymbol, price, alert.Direction, alert.PriceLevel, alert.PriceCurrency)
+	}
+
+	r.Notification = coinNotification(coinInfo)
+	r.Notification.Metric = metric
+	r.Notification.Direction = alert.Direction
+	r.Notification.CurrentValue = price
+	r.Notification.ThresholdValue = alert.PriceLevel
+	r.Notification.Currency = alert.PriceCurrency
+	setFXRate(&r.Notification, e.FX, rate)
+	return r, nil
+}
+
+// setFXRate records the rate a notification's values were converted at.
+func setFXRate(n *Notification, rates FXRates, rate float64) {
+	if n.Currency == "USD" || rate == 0 {
+		return
+	}
+	n.FXRate = rate
+	n.FXSource = rates.Source
+	asOf := rates.AsOf
+	n.FXRatesAt = &asOf
+}
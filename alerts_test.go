@@ -0,0 +1,235 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvaluation(coinInfos ...CoinInfo) evaluation {
+	now := time.Now()
+	for i := range coinInfos {
+		if coinInfos[i].LastUpdated == "" {
+			coinInfos[i].LastUpdated = strconv.FormatInt(now.Unix(), 10)
+		}
+	}
+	CoinDeltas := coinInfoMap(coinInfos)
+	return evaluation{CoinDeltas: CoinDeltas, CoinKeys: coinKeysByID(CoinDeltas), Now: now}
+}
+
+func TestValidatePriceAlert(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_PRICE, CoinSymbol: "BTC", CoinName: "Bitcoin", PriceLevel: 70000, Direction: "Above"}
+	if assert.NoError(t, validateAlert(&alert)) {
+		assert.Equal(t, DIRECTION_ABOVE, alert.Direction)
+		assert.Equal(t, "USD", alert.PriceCurrency)
+	}
+
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_PRICE, PriceLevel: 70000, Direction: "sideways"}))
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_PRICE, Direction: DIRECTION_BELOW}))
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_PRICE, PriceLevel: 1, Direction: DIRECTION_BELOW, PriceCurrency: "DOGE"}))
+	assert.Error(t, validateAlert(&Alert{Kind: "nope"}))
+}
+
+func TestEvaluatePriceAlert(t *testing.T) {
+	e := testEvaluation(CoinInfo{Symbol: "BTC", Name: "Bitcoin", PriceUSD: "70250.5", PriceBTC: "1"},
+		CoinInfo{Symbol: "ETH", Name: "Ethereum", PriceUSD: "3000", PriceBTC: "0.043"})
+
+	above := Alert{Kind: ALERT_KIND_PRICE, CoinSymbol: "BTC", CoinName: "Bitcoin", PriceLevel: 70000,
+		Direction: DIRECTION_ABOVE, PriceCurrency: "USD"}
+	r, err := evaluateAlert(above, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, 70250.5, r.Notification.CurrentValue)
+		assert.Equal(t, "price_usd", r.Notification.Metric)
+		assert.Equal(t, ALERT_KIND_PRICE, r.Notification.Kind)
+	}
+
+	below := Alert{Kind: ALERT_KIND_PRICE, CoinSymbol: "ETH", CoinName: "Ethereum", PriceLevel: 0.04,
+		Direction: DIRECTION_BELOW, PriceCurrency: "BTC"}
+	r, err = evaluateAlert(below, e)
+	assert.NoError(t, err)
+	assert.False(t, r.Violation)
+}
+
+func TestEvaluateAlertMissingCoin(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_PRICE, CoinSymbol: "XYZ", CoinName: "Nothing", PriceLevel: 1, Direction: DIRECTION_ABOVE}
+	_, err := evaluateAlert(alert, testEvaluation())
+	assert.Equal(t, errStaleQuote, err)
+}
+
+func TestAlertFiresOnlyOnCrossing(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_PRICE, CoinSymbol: "BTC", CoinName: "Bitcoin", PriceLevel: 70000,
+		Direction: DIRECTION_ABOVE, PriceCurrency: "USD", Hysteresis: 500}
+
+	var fired []bool
+	for _, price := range []string{"69000", "70100", "70500", "69800", "70200", "69400", "70050"} {
+		r, err := evaluateAlert(alert, testEvaluation(CoinInfo{Symbol: "BTC", Name: "Bitcoin", PriceUSD: price}))
+		assert.NoError(t, err)
+		fired = append(fired, advanceAlertState(&alert, r, time.Now()))
+	}
+
+	// Dipping to 69800 is inside the 500 band, so 70200 does not re-fire;
+	// 69400 re-arms and 70050 fires again.
+	assert.Equal(t, []bool{false, true, false, false, false, false, true}, fired)
+}
+
+func TestChangeAlertRearmsWithHysteresis(t *testing.T) {
+	alert := Alert{CoinSymbol: "BTC", CoinName: "Bitcoin", ThresholdDelta: -5, TimeDelta: "24h", Hysteresis: 1}
+
+	var fired []bool
+	for _, change := range []string{"-6", "-4.5", "-5.5", "-3.9", "-5.1"} {
+		r, err := evaluateAlert(alert, testEvaluation(CoinInfo{Symbol: "BTC", Name: "Bitcoin", PriceUSD: "1", Change24h: change}))
+		assert.NoError(t, err)
+		fired = append(fired, advanceAlertState(&alert, r, time.Now()))
+	}
+
+	assert.Equal(t, []bool{true, false, false, false, true}, fired)
+}
+
+func TestValidateCooldown(t *testing.T) {
+	assert.NoError(t, validateCooldown(&Alert{}, plans[PLAN_FREE]))
+	assert.Error(t, validateCooldown(&Alert{CooldownMinutes: 60}, plans[PLAN_FREE]))
+	assert.NoError(t, validateCooldown(&Alert{CooldownMinutes: 60}, plans[PLAN_PRO]))
+	assert.Error(t, validateCooldown(&Alert{CooldownMinutes: -5}, plans[PLAN_DESK]))
+	assert.Equal(t, "12 hours", formatCooldown(720))
+	assert.Equal(t, "90 minutes", formatCooldown(90))
+}
+
+func TestEvaluateRankAlert(t *testing.T) {
+	enters := Alert{Kind: ALERT_KIND_RANK, CoinSymbol: "SOL", CoinName: "Solana", Threshold: 10, Direction: "Enters"}
+	assert.NoError(t, validateAlert(&enters))
+	exits := Alert{Kind: ALERT_KIND_RANK, CoinSymbol: "SOL", CoinName: "Solana", Threshold: 5, Direction: DIRECTION_EXITS}
+	assert.NoError(t, validateAlert(&exits))
+
+	for rank, expected := range map[string][]bool{"11": {false, true}, "10": {true, true}, "5": {true, false}} {
+		e := testEvaluation(CoinInfo{Symbol: "SOL", Name: "Solana", Rank: rank})
+		r, err := evaluateAlert(enters, e)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[0], r.Violation, "enters top 10 at rank "+rank)
+		r, err = evaluateAlert(exits, e)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[1], r.Violation, "exits top 5 at rank "+rank)
+	}
+}
+
+func TestEvaluateMarketCapAlert(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_MARKET_CAP, CoinSymbol: "ETH", CoinName: "Ethereum", Threshold: 5e11, Direction: DIRECTION_ABOVE}
+	assert.NoError(t, validateAlert(&alert))
+
+	r, err := evaluateAlert(alert, testEvaluation(CoinInfo{Symbol: "ETH", Name: "Ethereum", MarketCapUSD: "510000000000"}))
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, "market_cap_usd", r.Notification.Metric)
+		assert.Equal(t, 5.1e11, r.Notification.CurrentValue)
+	}
+}
+
+func TestEvaluateMarketCapAlertWithoutCurrency(t *testing.T) {
+	// Stored before market cap alerts had a quote currency.
+	alert := Alert{Kind: ALERT_KIND_MARKET_CAP, CoinSymbol: "ETH", CoinName: "Ethereum", Threshold: 5e11, Direction: DIRECTION_ABOVE}
+	r, err := evaluateAlert(alert, testEvaluation(CoinInfo{Symbol: "ETH", Name: "Ethereum", MarketCapUSD: "510000000000"}))
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, "market_cap_usd", r.Notification.Metric)
+		assert.Equal(t, "USD", r.Notification.Currency)
+		assert.Equal(t, 5.1e11, r.Notification.CurrentValue)
+		assert.Zero(t, r.Notification.FXRate)
+	}
+}
+
+func TestEvaluatePairAlert(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_PAIR, CoinSymbol: "ETH", CoinName: "Ethereum", QuoteSymbol: "BTC", QuoteName: "Bitcoin",
+		Threshold: 0.05, Direction: "below"}
+	assert.NoError(t, validateAlert(&alert))
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_PAIR, CoinSymbol: "ETH", CoinName: "Ethereum",
+		QuoteSymbol: "eth", QuoteName: "ethereum", Threshold: 1, Direction: DIRECTION_ABOVE}))
+	assert.NoError(t, validateAlert(&Alert{Kind: ALERT_KIND_PAIR, CoinID: "ethereum", QuoteID: "bitcoin",
+		Threshold: 1, Direction: DIRECTION_ABOVE}), "quote resolved by ID")
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_PAIR, CoinSymbol: "ETH", QuoteName: "Bitcoin",
+		Threshold: 1, Direction: DIRECTION_ABOVE}))
+
+	e := testEvaluation(CoinInfo{Symbol: "ETH", Name: "Ethereum", PriceUSD: "2900"},
+		CoinInfo{Symbol: "BTC", Name: "Bitcoin", PriceUSD: "60000"})
+	r, err := evaluateAlert(alert, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, "ETH/BTC", r.Notification.CoinSymbol)
+		assert.InDelta(t, 0.048333, r.Notification.CurrentValue, 1e-6)
+		assert.Contains(t, notificationDetail(r.Notification), "<b>Current Ratio</b>: 0.0483333")
+	}
+}
+
+func TestSnapshotRatio(t *testing.T) {
+	ratio, err := snapshotRatio(PriceSnapshot{CoinKey: "ETH_ETHEREUM", PriceUSD: 3000}, PriceSnapshot{CoinKey: "BTC_BITCOIN", PriceUSD: 60000})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0.05, ratio)
+	}
+	_, err = snapshotRatio(PriceSnapshot{CoinKey: "ETH_ETHEREUM"}, PriceSnapshot{CoinKey: "BTC_BITCOIN", PriceUSD: 60000})
+	assert.EqualError(t, err, "no usable price for ETH_ETHEREUM at 0001-01-01 00:00:00 +0000 UTC")
+	_, err = snapshotRatio(PriceSnapshot{CoinKey: "ETH_ETHEREUM", PriceUSD: 3000}, PriceSnapshot{CoinKey: "BTC_BITCOIN", PriceUSD: -1})
+	assert.Error(t, err)
+}
+
+func TestValidateIndicatorAlert(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_INDICATOR, CoinSymbol: "BTC", CoinName: "Bitcoin", Direction: DIRECTION_BELOW,
+		Indicator: &IndicatorSpec{Type: INDICATOR_RSI}}
+	if assert.NoError(t, validateAlert(&alert)) {
+		assert.Equal(t, "1h", alert.Indicator.Interval)
+		assert.Equal(t, 14, alert.Indicator.Period)
+		assert.Equal(t, 30.0, alert.Indicator.Level)
+	}
+
+	invalid := []IndicatorSpec{
+		{Type: "macd"},
+		{Type: INDICATOR_SMA_CROSS, Fast: 50, Slow: 10},
+		{Type: INDICATOR_EMA_CROSS, Fast: 10, Slow: 500},
+		{Type: INDICATOR_RSI, Interval: "5m"},
+		{Type: INDICATOR_RSI, Level: 120},
+		{Type: INDICATOR_BOLLINGER, Interval: "1w", Period: 60},
+	}
+	for _, spec := range invalid {
+		spec := spec
+		assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_INDICATOR, Direction: DIRECTION_ABOVE, Indicator: &spec}), spec.String())
+	}
+}
+
+func TestValidateVolatilityAlert(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_VOLATILITY, CoinSymbol: "BTC", CoinName: "Bitcoin", Threshold: 3}
+	if assert.NoError(t, validateAlert(&alert)) {
+		assert.Equal(t, "1h", alert.TimeDelta)
+		assert.Equal(t, "30d", alert.Lookback)
+	}
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_VOLATILITY, Threshold: -1}))
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_VOLATILITY, Threshold: 3, Direction: "sideways"}))
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_VOLATILITY, Threshold: 3, TimeDelta: "1d", Lookback: "7d"}))
+}
+
+func TestVolatilityEmailContent(t *testing.T) {
+	n := Notification{Kind: ALERT_KIND_VOLATILITY, TimeDelta: "1h", CurrentDelta: -4.2, CurrentValue: -3.5,
+		ThresholdValue: 3, ThresholdDelta: -3.6, Baseline: 1.2, Detail: "30d of 1h returns"}
+	assert.Contains(t, notificationDetail(n), "<b>Move (1h)</b>: -4.20% (-3.50 sigma)")
+	assert.Contains(t, notificationDetail(n), "±3.60% (1 sigma = 1.20% over 30d of 1h returns)")
+}
+
+func TestValidateDepegAlert(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_DEPEG, CoinSymbol: "usdc"}
+	if assert.NoError(t, validateAlert(&alert)) {
+		assert.Equal(t, "USD Coin", alert.CoinName)
+		assert.Equal(t, 1.0, alert.PriceLevel)
+		assert.Equal(t, float64(DEFAULT_DEPEG_BAND), alert.Threshold)
+		assert.Equal(t, DEFAULT_DEPEG_TICKS, alert.Ticks)
+	}
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_DEPEG, CoinSymbol: "XYZ"}))
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_DEPEG, CoinSymbol: "DAI", Ticks: MAX_DEPEG_TICKS + 1}))
+}
+
+func TestDepegEmailSection(t *testing.T) {
+	ns := []Notification{
+		{Kind: ALERT_KIND_CHANGE, CoinSymbol: "BTC", CurrentDelta: 12, ThresholdDelta: 10},
+		{Kind: ALERT_KIND_DEPEG, CoinSymbol: "USDT", CurrentValue: 0.994, Baseline: 1, ThresholdValue: 50,
+			Currency: "USD", Detail: "3 consecutive ticks"},
+	}
+	body := prettyPrintNotifications([]string{"btc", "usdt"}, ns)
+	assert.True(t, strings.Index(body, "Stablecoin De-peg Alerts") < strings.Index(body, "usdt"))
+	assert.True(t, strings.Index(body, "Other Alerts") < strings.Index(body, "btc"))
+	assert.Contains(t, body, "<b>Deviation From Peg</b>: -60.0 bp from 1.00 USD (band ±50 bp)")
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum results returned by a coin search.
+const COIN_SEARCH_LIMIT = 20
+
+// catalogID returns the stable ID of a fetched coin. Providers that do not
+// report one fall back to the coin key.
+func catalogID(key string, coinInfo CoinInfo) string {
+	if coinInfo.ID != "" {
+		return strings.ToLower(coinInfo.ID)
+	}
+	return strings.ToLower(key)
+}
+
+// pinCatalogIDs replaces the fetched IDs in prices with the IDs of the
+// catalog entries they belong to, so a coin keeps its ID when the provider
+// its ID came from misses a tick. A known ID is kept, which follows renames;
+// otherwise the coin is matched by its key.
+func pinCatalogIDs(prices map[string]CoinInfo, coins []Coin) {
+	ids := make(map[string]bool)
+	byKey := make(map[string]string)
+	for _, coin := range coins {
+		ids[coin.CoinID] = true
+		byKey[createCoinKey(coin.Symbol, coin.Name)] = coin.CoinID
+	}
+	for key, coinInfo := range prices {
+		if ids[catalogID(key, coinInfo)] {
+			continue
+		}
+		if id, ok := byKey[key]; ok {
+			coinInfo.ID = id
+			prices[key] = coinInfo
+		}
+	}
+}
+
+// coinKeysByID maps catalog IDs to keys in CoinDeltas.
+func coinKeysByID(CoinDeltas map[string]CoinInfo) map[string]string {
+	keys := make(map[string]string)
+	for key, coinInfo := range CoinDeltas {
+		keys[catalogID(key, coinInfo)] = key
+	}
+	return keys
+}
+
+// addAlias appends alias to a comma-separated alias list if it is not there yet.
+func addAlias(aliases string, alias string) string {
+	if alias == "" {
+		return aliases
+	}
+	for _, a := range strings.Split(aliases, ",") {
+		if strings.EqualFold(a, alias) {
+			return aliases
+		}
+	}
+	if aliases == "" {
+		return alias
+	}
+	return aliases + "," + alias
+}
+
+// updateCoinCatalog adds new coins to the catalog and records renames and
+// symbol changes as aliases of the existing entry. The IDs in prices are
+// pinned to the catalog's, so call it before keying alerts by ID.
+func updateCoinCatalog(prices map[string]CoinInfo, now time.Time) error {
+	var coins []Coin
+	if err := db.Find(&coins).Error; err != nil {
+		return err
+	}
+	pinCatalogIDs(prices, coins)
+	catalog := make(map[string]*Coin)
+	for i := range coins {
+		catalog[coins[i].CoinID] = &coins[i]
+	}
+
+	tx := db.Begin()
+	var seen []string
+	for key, coinInfo := range prices {
+		id := catalogID(key, coinInfo)
+		rank, _ := strconv.Atoi(coinInfo.Rank)
+		coin, ok := catalog[id]
+		if !ok {
+			coin = &Coin{CoinID: id, Symbol: coinInfo.Symbol, Name: coinInfo.Name, Rank: rank, LastSeenAt: now}
+			catalog[id] = coin
+			tx.Create(coin)
+			continue
+		}
+		seen = append(seen, id)
+		if coin.Symbol == coinInfo.Symbol && coin.Name == coinInfo.Name && coin.Rank == rank {
+			continue
+		}
+		if coin.Symbol != coinInfo.Symbol {
+			coin.Aliases = addAlias(coin.Aliases, coin.Symbol)
+		}
+		if coin.Name != coinInfo.Name {
+			coin.Aliases = addAlias(coin.Aliases, coin.Name)
+		}
+		tx.Model(coin).UpdateColumns(map[string]interface{}{
+			"symbol": coinInfo.Symbol, "name": coinInfo.Name, "rank": rank, "aliases": coin.Aliases,
+		})
+	}
+	if len(seen) > 0 {
+		tx.Model(&Coin{}).Where("coin_id IN (?)", seen).UpdateColumn("last_seen_at", now)
+	}
+	return tx.Commit().Error
+}
+
+// catalogCoinInfos returns the catalog keyed by coin key, for resolving coin
+// references the way evaluation does against fetched prices.
+func catalogCoinInfos() (map[string]CoinInfo, error) {
+	var coins []Coin
+	if err := db.Find(&coins).Error; err != nil {
+		return nil, err
+	}
+	infos := make(map[string]CoinInfo)
+	for _, coin := range coins {
+		infos[createCoinKey(coin.Symbol, coin.Name)] = CoinInfo{ID: coin.CoinID, Symbol: coin.Symbol, Name: coin.Name}
+	}
+	return infos, nil
+}
+
+// catalogCoin returns the catalog entry with the given ID.
+func catalogCoin(id string) (Coin, error) {
+	var coin Coin
+	err := db.Where("coin_id = ?", strings.ToLower(id)).First(&coin).Error
+	if err != nil {
+		return coin, fmt.Errorf("unknown coin id %q", id)
+	}
+	return coin, nil
+}
+
+// resolveCoin finds the single catalog entry matching ref by ID, current
+// symbol or name, or a former symbol or name.
+func resolveCoin(ref string) (Coin, error) {
+	r := strings.ToLower(strings.TrimSpace(ref))
+	if r == "" {
+		return Coin{}, errors.New("missing coin")
+	}
+	if coin, err := catalogCoin(r); err == nil {
+		return coin, nil
+	}
+
+	var matches []Coin
+	err := db.Where("LOWER(symbol) = ? OR LOWER(name) = ?", r, r).Order("rank").Find(&matches).Error
+	if err == nil && len(matches) == 0 {
+		err = db.Where("',' || LOWER(aliases) || ',' LIKE ?", "%,"+r+",%").Find(&matches).Error
+	}
+	if err != nil {
+		return Coin{}, err
+	}
+	switch len(matches) {
+	case 0:
+		return Coin{}, fmt.Errorf("unknown coin %q", ref)
+	case 1:
+		return matches[0], nil
+	}
+	var ids []string
+	for _, m := range matches {
+		ids = append(ids, m.CoinID)
+	}
+	return Coin{}, fmt.Errorf("coin %q is ambiguous, use one of the coin ids: %s", ref, strings.Join(ids, ", "))
+}
+
+// resolveCoinRef resolves a coin given on an alert, preferring the stable ID,
+// then an exact symbol and name match, then any unique match.
+func resolveCoinRef(id string, symbol string, name string) (Coin, error) {
+	if id != "" {
+		return catalogCoin(id)
+	}
+	if symbol != "" && name != "" {
+		var coin Coin
+		err := db.Where("LOWER(symbol) = ? AND LOWER(name) = ?", strings.ToLower(symbol), strings.ToLower(name)).
+			First(&coin).Error
+		if err == nil {
+			return coin, nil
+		}
+	}
+	if symbol != "" {
+		return resolveCoin(symbol)
+	}
+	return resolveCoin(name)
+}
+
+// Alert kinds that are not evaluated on a single coin. Listing alerts may
+// name coins that are not listed yet, and composite rules resolve their own
+// coins.
+var coinlessKinds = map[string]bool{
+	ALERT_KIND_MARKET:           true,
+	ALERT_KIND_PORTFOLIO_VALUE:  true,
+	ALERT_KIND_ALLOCATION_DRIFT: true,
+	ALERT_KIND_LISTING:          true,
+	ALERT_KIND_COMPOSITE:        true,
+}
+
+// resolveAlertCoins checks that the coins an alert is evaluated on exist in
+// the catalog and pins them to their catalog IDs.
+func resolveAlertCoins(alert *Alert) error {
+	kind := alertKindOf(*alert)
+	if coinlessKinds[kind] {
+		return nil
+	}
+	if alert.CoinID == "" && alert.CoinSymbol == "" && alert.CoinName == "" {
+		return fmt.Errorf("%s alerts need coin_id, coin_symbol or coin_name", kind)
+	}
+	coin, err := resolveCoinRef(alert.CoinID, alert.CoinSymbol, alert.CoinName)
+	if err != nil {
+		return err
+	}
+	alert.CoinID, alert.CoinSymbol, alert.CoinName = coin.CoinID, coin.Symbol, coin.Name
+	if kind != ALERT_KIND_PAIR {
+		return nil
+	}
+	quote, err := resolveCoinRef(alert.QuoteID, alert.QuoteSymbol, alert.QuoteName)
+	if err != nil {
+		return err
+	}
+	if quote.CoinID == coin.CoinID {
+		return errors.New("pair alerts need two different coins")
+	}
+	alert.QuoteID, alert.QuoteSymbol, alert.QuoteName = quote.CoinID, quote.Symbol, quote.Name
+	return nil
+}
+
+// searchCoins returns catalog entries whose ID, symbol, name or aliases
+// contain q, highest ranked first.
+func searchCoins(q string) ([]Coin, error) {
+	var coins []Coin
+	query := db.Order("CASE WHEN rank = 0 THEN 1 ELSE 0 END, rank").Limit(COIN_SEARCH_LIMIT)
+	if q = strings.ToLower(strings.TrimSpace(q)); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("LOWER(coin_id) LIKE ? OR LOWER(symbol) LIKE ? OR LOWER(name) LIKE ? OR LOWER(aliases) LIKE ?",
+			like, like, like, like)
+	}
+	err := query.Find(&coins).Error
+	return coins, err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddAlias(t *testing.T) {
+	tests := []struct {
+		aliases  string
+		alias    string
+		expected string
+	}{
+		{"", "MATIC", "MATIC"},
+		{"MATIC", "Polygon", "MATIC,Polygon"},
+		{"MATIC,Polygon", "polygon", "MATIC,Polygon"},
+		{"MATIC", "", "MATIC"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, addAlias(tt.aliases, tt.alias))
+	}
+}
+
+func TestLookupCoinByCatalogID(t *testing.T) {
+	// The coin was renamed after the alert was created.
+	e := testEvaluation(CoinInfo{ID: "matic-network", Symbol: "POL", Name: "Polygon Ecosystem Token", PriceUSD: "0.5"})
+
+	alert := Alert{CoinID: "matic-network", CoinSymbol: "MATIC", CoinName: "Polygon"}
+	key, coinInfo, err := lookupCoin(alert, e)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "POL_POLYGON ECOSYSTEM TOKEN", key)
+		assert.Equal(t, "0.5", coinInfo.PriceUSD)
+	}
+
+	_, _, err = lookupCoin(Alert{CoinSymbol: "MATIC", CoinName: "Polygon"}, e)
+	assert.Equal(t, errStaleQuote, err)
+}
+
+func TestPinCatalogIDs(t *testing.T) {
+	coins := []Coin{{CoinID: "bitcoin", Symbol: "BTC", Name: "Bitcoin"}, {CoinID: "matic-network", Symbol: "POL", Name: "Polygon Ecosystem Token"}}
+	prices := map[string]CoinInfo{
+		// The pinned provider missed this tick; another provider's ID came through.
+		"BTC_BITCOIN": {ID: "btc", Symbol: "BTC", Name: "Bitcoin"},
+		// Renamed since it was cataloged: the ID still matches.
+		"POL_POLYGON":  {ID: "matic-network", Symbol: "POL", Name: "Polygon"},
+		"ETH_ETHEREUM": {ID: "ethereum", Symbol: "ETH", Name: "Ethereum"},
+	}
+	pinCatalogIDs(prices, coins)
+	assert.Equal(t, "bitcoin", prices["BTC_BITCOIN"].ID)
+	assert.Equal(t, "matic-network", prices["POL_POLYGON"].ID)
+	assert.Equal(t, "ethereum", prices["ETH_ETHEREUM"].ID)
+	assert.Equal(t, "BTC_BITCOIN", coinKeysByID(prices)["bitcoin"])
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// Chat integration kinds, matching the notifier kinds that use them.
+const (
+	CHAT_SLACK    = "slack"
+	CHAT_DISCORD  = "discord"
+	CHAT_TELEGRAM = "telegram"
+)
+
+const TELEGRAM_API = "https://api.telegram.org"
+
+// Message limits of the chat APIs. Notifications past a limit are summarized
+// in a trailing "and N more" line.
+const (
+	SLACK_MAX_BLOCKS        = 50
+	SLACK_MAX_FIELDS        = 10 // per section block.
+	DISCORD_MAX_EMBEDS      = 10 // per message; larger batches are split.
+	DISCORD_MAX_FIELDS      = 25 // per embed.
+	TELEGRAM_MAX_MESSAGE    = 4096
+	CHAT_EMBED_COLOR        = 0xFF9F00 // the email banner color.
+	CHAT_TIME_FORMAT        = "2006-01-02 15:04 MST"
+	TELEGRAM_MARKDOWN_CHARS = "_*[]()~`>#+-=|{}.!\\"
+)
+
+// chatCard is the content of one notification as shown in chat: the same
+// fields prettyPrintNotifications puts in emails.
+type chatCard struct {
+	Title    string // the alert name.
+	Fields   []detailField
+	AsOf     time.Time
+	Cooldown string
+}
+
+func messageCards(m Message) []chatCard {
+	var cards []chatCard
+	for i, n := range m.Notifications {
+		fields := append([]detailField{stringField("Coin", fmt.Sprintf("%s (%s)", n.CoinSymbol, n.CoinName))},
+			notificationFields(n)...)
+		asOf, _ := msToTime(n.LastUpdated)
+		cards = append(cards, chatCard{Title: m.AlertNames[i], Fields: fields, AsOf: asOf.UTC(),
+			Cooldown: formatCooldown(n.CooldownMinutes)})
+	}
+	return cards
+}
+
+// fieldValue keeps chat APIs from rejecting empty field values.
+func fieldValue(f detailField) string {
+	if f.Value == "" {
+		return "-"
+	}
+	return f.Value
+}
+
+// validateChatIntegration checks a user's chat integration.
+func validateChatIntegration(c *ChatIntegration) error {
+	if c.Email == "" {
+		return errors.New("missing email")
+	}
+	c.Kind = strings.ToLower(c.Kind)
+	switch c.Kind {
+	case CHAT_SLACK, CHAT_DISCORD:
+		u, err := neturl.Parse(c.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%s integrations need an https webhook url", c.Kind)
+		}
+	case CHAT_TELEGRAM:
+		if c.ChatID == "" {
+			return errors.New("telegram integrations need a chat_id")
+		}
+	default:
+		return fmt.Errorf("kind must be %q, %q or %q", CHAT_SLACK, CHAT_DISCORD, CHAT_TELEGRAM)
+	}
+	return nil
+}
+
+// userChatIntegrations returns a user's integrations of one kind.
+func userChatIntegrations(email string, kind string) ([]ChatIntegration, error) {
+	var integrations []ChatIntegration
+	err := db.Where("email = ? AND kind = ?", email, kind).Order("id").Find(&integrations).Error
+	return integrations, err
+}
+
+// chatNotifier sends to each of the user's integrations of its kind. When
+// the outbox retries a message, integrations that already got it are skipped.
+type chatNotifier struct {
+	config       NotifierConfig
+	integrations func(email string, kind string) ([]ChatIntegration, error)
+	receipts     deliveryReceipts
+}
+
+func newChatNotifier(config NotifierConfig) chatNotifier {
+	return chatNotifier{config, userChatIntegrations, storedReceipts}
+}
+
+func (n *chatNotifier) Name() string {
+	return n.config.Name
+}
+
+// sendEach calls send for each integration with the part of m it has not
+// received yet.
+func (n *chatNotifier) sendEach(m Message, send func(target ChatIntegration, m Message) error) error {
+	targets, err := n.integrations(m.Email, n.config.Kind)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("%s has no %s integration", m.Email, n.config.Kind)
+	}
+	var failed []string
+	for _, target := range targets {
+		err := n.receipts.sendMissing(n.config.Name, target.ID, m, func(part Message) error {
+			return send(target, part)
+		})
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// slackNotifier posts Block Kit messages to Slack incoming webhooks.
+type slackNotifier struct {
+	chatNotifier
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Fields   []slackText `json:"fields,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"` // shown in notifications and by clients without blocks.
+	Blocks []slackBlock `json:"blocks"`
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackMarkdown(text string) *slackText {
+	return &slackText{"mrkdwn", text}
+}
+
+func slackBlocks(m Message) []slackBlock {
+	header := m.Subject
+	if len(header) > 150 {
+		header = header[:147] + "..."
+	}
+	blocks := []slackBlock{{Type: "header", Text: &slackText{"plain_text", header}}}
+	cards := messageCards(m)
+	for i, card := range cards {
+		// Each card takes three blocks; keep one free for the overflow line.
+		if len(blocks)+4 > SLACK_MAX_BLOCKS {
+			more := fmt.Sprintf("_and %d more alerts_", len(cards)-i)
+			blocks = append(blocks, slackBlock{Type: "section", Text: slackMarkdown(more)})
+			break
+		}
+		section := slackBlock{Type: "section", Text: slackMarkdown("*" + slackEscaper.Replace(card.Title) + "*")}
+		for _, f := range card.Fields[:min(len(card.Fields), SLACK_MAX_FIELDS)] {
+			section.Fields = append(section.Fields, *slackMarkdown("*" + slackEscaper.Replace(f.Name) + "*\n" +
+				slackEscaper.Replace(fieldValue(f))))
+		}
+		context := slackBlock{Type: "context", Elements: []slackText{*slackMarkdown(fmt.Sprintf("As of %s · next alert no sooner than %s",
+			card.AsOf.Format(CHAT_TIME_FORMAT), card.Cooldown))}}
+		blocks = append(blocks, section, context, slackBlock{Type: "divider"})
+	}
+	return blocks
+}
+
+func (n *slackNotifier) Send(m Message) error {
+	return n.sendEach(m, func(target ChatIntegration, m Message) error {
+		_, err := postJSON(target.URL, slackMessage{Text: messageText(m), Blocks: slackBlocks(m)})
+		return err
+	})
+}
+
+// discordNotifier posts embeds to Discord channel webhooks.
+type discordNotifier struct {
+	chatNotifier
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+type discordEmbed struct {
+	Title     string         `json:"title"`
+	Color     int            `json:"color"`
+	Fields    []discordField `json:"fields"`
+	Footer    *discordFooter `json:"footer,omitempty"`
+	Timestamp string         `json:"timestamp,omitempty"` // ISO 8601, shown in the reader's time zone.
+}
+
+type discordMessage struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []discordEmbed `json:"embeds"`
+}
+
+// discordMessages renders a message as one embed per notification, split
+// into as many posts as the embed limit requires.
+func discordMessages(m Message) []discordMessage {
+	var messages []discordMessage
+	for i, card := range messageCards(m) {
+		embed := discordEmbed{Title: card.Title, Color: CHAT_EMBED_COLOR,
+			Footer:    &discordFooter{"Next alert no sooner than " + card.Cooldown},
+			Timestamp: card.AsOf.Format(time.RFC3339)}
+		for _, f := range card.Fields[:min(len(card.Fields), DISCORD_MAX_FIELDS)] {
+			embed.Fields = append(embed.Fields, discordField{f.Name, fieldValue(f), true})
+		}
+		if i%DISCORD_MAX_EMBEDS == 0 {
+			messages = append(messages, discordMessage{})
+		}
+		messages[len(messages)-1].Embeds = append(messages[len(messages)-1].Embeds, embed)
+	}
+	if len(messages) > 0 {
+		messages[0].Content = m.Subject
+	}
+	return messages
+}
+
+func (n *discordNotifier) Send(m Message) error {
+	return n.sendEach(m, func(target ChatIntegration, m Message) error {
+		for _, message := range discordMessages(m) {
+			if _, err := postJSON(target.URL, message); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// telegramNotifier sends MarkdownV2 messages through the Telegram Bot API.
+// The bot token is the operator's; users only choose the chat.
+type telegramNotifier struct {
+	chatNotifier
+}
+
+// telegramEscape escapes text for MarkdownV2, where every reserved
+// character outside of an entity must be escaped.
+func telegramEscape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(TELEGRAM_MARKDOWN_CHARS, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// telegramText renders a message in MarkdownV2. Whole notifications are
+// dropped to stay under the message limit, so no entity is cut in half.
+func telegramText(m Message) string {
+	text := "*" + telegramEscape(m.Subject) + "*"
+	cards := messageCards(m)
+	for i, card := range cards {
+		lines := []string{"", "*" + telegramEscape(card.Title) + "*"}
+		for _, f := range card.Fields {
+			lines = append(lines, "• *"+telegramEscape(f.Name)+"*: "+telegramEscape(fieldValue(f)))
+		}
+		lines = append(lines, "_"+telegramEscape("As of "+card.AsOf.Format(CHAT_TIME_FORMAT))+"_")
+		block := strings.Join(lines, "\n")
+		more := "\n\n_" + telegramEscape(fmt.Sprintf("and %d more alerts", len(cards)-i)) + "_"
+		if len(text)+len(block)+len(more) > TELEGRAM_MAX_MESSAGE {
+			return text + more
+		}
+		text += block
+	}
+	return text
+}
+
+func (n *telegramNotifier) Send(m Message) error {
+	base := n.config.URL
+	if base == "" {
+		base = TELEGRAM_API
+	}
+	url := strings.TrimSuffix(base, "/") + "/bot" + n.config.Token + "/sendMessage"
+	return n.sendEach(m, func(target ChatIntegration, m Message) error {
+		resp, err := postJSON(url, map[string]string{"chat_id": target.ChatID, "text": telegramText(m), "parse_mode": "MarkdownV2"})
+		if err != nil {
+			return err
+		}
+		var result struct {
+			OK          bool   `json:"ok"`
+			Description string `json:"description"`
+		}
+		if err := resp.JSON(&result); err != nil {
+			return err
+		}
+		if !result.OK {
+			return errors.New("telegram: " + result.Description)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withIntegrations points a chat notifier at fixed integrations instead of
+// the user's stored ones, keeping its delivery receipts in memory.
+func withIntegrations(n Notifier, integrations ...ChatIntegration) Notifier {
+	lookup := func(email string, kind string) ([]ChatIntegration, error) { return integrations, nil }
+	var c *chatNotifier
+	switch notifier := n.(type) {
+	case *slackNotifier:
+		c = &notifier.chatNotifier
+	case *discordNotifier:
+		c = &notifier.chatNotifier
+	case *telegramNotifier:
+		c = &notifier.chatNotifier
+	}
+	c.integrations, c.receipts = lookup, memoryReceipts()
+	return n
+}
+
+func manyAlerts(count int) Message {
+	m := testMessage()
+	for i := 1; i < count; i++ {
+		m.AlertNames = append(m.AlertNames, "alert "+strconv.Itoa(i))
+		m.Notifications = append(m.Notifications, m.Notifications[0])
+	}
+	return m
+}
+
+func TestSlackNotifierBlocks(t *testing.T) {
+	server, body, path := recordingServer("ok")
+	defer server.Close()
+
+	n, _ := newNotifier(NotifierConfig{Kind: "slack"})
+	withIntegrations(n, ChatIntegration{Kind: CHAT_SLACK, URL: server.URL + "/services/T0/B0/user"})
+	if !assert.NoError(t, n.Send(testMessage())) {
+		return
+	}
+	assert.Equal(t, "/services/T0/B0/user", *path)
+	var payload slackMessage
+	assert.NoError(t, json.Unmarshal(*body, &payload))
+	assert.Contains(t, payload.Text, "BTC over 70k: BTC (Bitcoin)")
+	if assert.Len(t, payload.Blocks, 4) {
+		assert.Equal(t, "header", payload.Blocks[0].Type)
+		section := payload.Blocks[1]
+		assert.Equal(t, "*BTC over 70k*", section.Text.Text)
+		assert.Equal(t, "*Coin*\nBTC (Bitcoin)", section.Fields[0].Text)
+		assert.Equal(t, "*Current Price*\n70250.00 USD", section.Fields[1].Text)
+		assert.Equal(t, "*Alert Level*\nabove 70000.00 USD", section.Fields[2].Text)
+		assert.Contains(t, payload.Blocks[2].Elements[0].Text, "As of 2024-03-01 12:00 UTC")
+		assert.Equal(t, "divider", payload.Blocks[3].Type)
+	}
+
+	blocks := slackBlocks(manyAlerts(30))
+	assert.True(t, len(blocks) <= SLACK_MAX_BLOCKS)
+	assert.Equal(t, "_and 14 more alerts_", blocks[len(blocks)-1].Text.Text)
+}
+
+func TestDiscordNotifierEmbeds(t *testing.T) {
+	var posts []discordMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message discordMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		posts = append(posts, message)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	n, _ := newNotifier(NotifierConfig{Kind: "discord"})
+	withIntegrations(n, ChatIntegration{Kind: CHAT_DISCORD, URL: server.URL})
+	if !assert.NoError(t, n.Send(manyAlerts(12))) || !assert.Len(t, posts, 2) {
+		return
+	}
+	assert.Equal(t, testMessage().Subject, posts[0].Content)
+	assert.Len(t, posts[0].Embeds, DISCORD_MAX_EMBEDS)
+	assert.Len(t, posts[1].Embeds, 2)
+	assert.Empty(t, posts[1].Content)
+
+	embed := posts[0].Embeds[0]
+	assert.Equal(t, "BTC over 70k", embed.Title)
+	assert.Equal(t, "2024-03-01T12:00:00Z", embed.Timestamp)
+	assert.Equal(t, discordField{"Current Price", "70250.00 USD", true}, embed.Fields[1])
+	assert.Equal(t, discordField{"Alert Level", "above 70000.00 USD", true}, embed.Fields[2])
+}
+
+func TestTelegramNotifierMarkdown(t *testing.T) {
+	server, body, path := recordingServer(`{"ok":true,"result":{"message_id":1}}`)
+	defer server.Close()
+
+	n, _ := newNotifier(NotifierConfig{Kind: "telegram", URL: server.URL, Token: "123:abc"})
+	withIntegrations(n, ChatIntegration{Kind: CHAT_TELEGRAM, ChatID: "-10042"})
+	if assert.NoError(t, n.Send(testMessage())) {
+		assert.Equal(t, "/bot123:abc/sendMessage", *path)
+		var payload map[string]string
+		assert.NoError(t, json.Unmarshal(*body, &payload))
+		assert.Equal(t, "-10042", payload["chat_id"])
+		assert.Equal(t, "MarkdownV2", payload["parse_mode"])
+		assert.Contains(t, payload["text"], "*BTC over 70k*\n• *Coin*: BTC \\(Bitcoin\\)\n• *Current Price*: 70250\\.00 USD")
+		assert.Contains(t, payload["text"], "_As of 2024\\-03\\-01 12:00 UTC_")
+	}
+
+	text := telegramText(manyAlerts(100))
+	assert.True(t, len(text) <= TELEGRAM_MAX_MESSAGE)
+	assert.True(t, strings.HasSuffix(text, " more alerts_"))
+
+	rejected, _, _ := recordingServer(`{"ok":false,"description":"Bad Request: chat not found"}`)
+	defer rejected.Close()
+	n, _ = newNotifier(NotifierConfig{Kind: "telegram", URL: rejected.URL, Token: "123:abc"})
+	withIntegrations(n, ChatIntegration{Kind: CHAT_TELEGRAM, ChatID: "nope"})
+	assert.EqualError(t, n.Send(testMessage()), "telegram: Bad Request: chat not found")
+}
+
+func TestTelegramEscape(t *testing.T) {
+	assert.Equal(t, "BTC\\-USD \\+5\\.2% \\(1h\\)", telegramEscape("BTC-USD +5.2% (1h)"))
+	assert.Equal(t, "a\\_b\\*c\\\\", telegramEscape("a_b*c\\"))
+}
+
+func TestChatNotifierWithoutTarget(t *testing.T) {
+	n, _ := newNotifier(NotifierConfig{Kind: "slack"})
+	withIntegrations(n)
+	assert.EqualError(t, n.Send(testMessage()), "user@example.com has no slack integration")
+
+	// The operator's telegram chat is never used in place of the user's own.
+	server, _, path := recordingServer(`{"ok":true}`)
+	defer server.Close()
+	n, _ = newNotifier(NotifierConfig{Kind: "telegram", URL: server.URL, Token: "123:abc"})
+	withIntegrations(n)
+	assert.EqualError(t, n.Send(testMessage()), "user@example.com has no telegram integration")
+	assert.Empty(t, *path)
+}
+
+func TestChatNotifierRetriesFailedIntegrations(t *testing.T) {
+	var okPosts, flakyPosts int
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		okPosts++
+	}))
+	defer ok.Close()
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flakyPosts++
+		if flakyPosts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer flaky.Close()
+
+	first, second := ChatIntegration{Kind: CHAT_SLACK, URL: ok.URL}, ChatIntegration{Kind: CHAT_SLACK, URL: flaky.URL}
+	first.ID, second.ID = 1, 2
+	n, _ := newNotifier(NotifierConfig{Name: "slack", Kind: "slack"})
+	withIntegrations(n, first, second)
+	assert.Error(t, n.Send(testMessage()))
+	assert.NoError(t, n.Send(testMessage()))
+	assert.Equal(t, 1, okPosts, "the integration that got the message is skipped")
+	assert.Equal(t, 2, flakyPosts)
+	assert.NoError(t, n.Send(testMessage()))
+	assert.Equal(t, 2, flakyPosts)
+}
+
+func TestChatNotifierErrorHidesURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n, _ := newNotifier(NotifierConfig{Kind: "slack"})
+	withIntegrations(n, ChatIntegration{URL: server.URL + "/services/SECRET"})
+	err := n.Send(testMessage())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "500")
+		assert.NotContains(t, err.Error(), "SECRET")
+	}
+}
+
+func TestValidateChatIntegration(t *testing.T) {
+	c := ChatIntegration{Email: "user@example.com", Kind: "Slack", URL: "http://hooks.example.com/x"}
+	assert.Error(t, validateChatIntegration(&c))
+	c.URL = "https://hooks.example.com/x"
+	assert.NoError(t, validateChatIntegration(&c))
+	assert.Equal(t, CHAT_SLACK, c.Kind)
+
+	c = ChatIntegration{Email: "user@example.com", Kind: CHAT_TELEGRAM}
+	assert.Error(t, validateChatIntegration(&c))
+	c.ChatID = "42"
+	assert.NoError(t, validateChatIntegration(&c))
+
+	c.Kind = "irc"
+	assert.Error(t, validateChatIntegration(&c))
+}
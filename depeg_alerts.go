@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ALERT_KIND_DEPEG watches a stablecoin and fires once its USD price has
+// stayed outside a basis-point band around the peg for Ticks consecutive
+// scheduler ticks.
+const ALERT_KIND_DEPEG = "depeg"
+
+const (
+	DEFAULT_DEPEG_BAND  = 50 // basis points.
+	DEFAULT_DEPEG_TICKS = 3
+	DEFAULT_PEG         = 1.0
+	MAX_DEPEG_TICKS     = 48 // one day of ticks.
+)
+
+// STABLECOINS are the coins a de-peg alert can be created for by symbol
+// alone. Other coins can be watched by also giving their name.
+var STABLECOINS = map[string]string{
+	"USDT":  "Tether",
+	"USDC":  "USD Coin",
+	"DAI":   "Dai",
+	"BUSD":  "Binance USD",
+	"TUSD":  "TrueUSD",
+	"USDP":  "Pax Dollar",
+	"FDUSD": "First Digital USD",
+	"PYUSD": "PayPal USD",
+}
+
+func init() {
+	registerAlertKind(ALERT_KIND_DEPEG, alertKind{validateDepegAlert, evaluateDepegAlert})
+}
+
+func validateDepegAlert(alert *Alert) error {
+	alert.CoinSymbol = strings.ToUpper(alert.CoinSymbol)
+	if alert.CoinName == "" {
+		alert.CoinName = STABLECOINS[alert.CoinSymbol]
+	}
+	if alert.CoinSymbol == "" || alert.CoinName == "" {
+		return errors.New("de-peg alerts need a known stablecoin symbol, or a coin_symbol and coin_name")
+	}
+	if alert.PriceLevel == 0 {
+		alert.PriceLevel = DEFAULT_PEG
+	}
+	if alert.Threshold == 0 {
+		alert.Threshold = DEFAULT_DEPEG_BAND
+	}
+	if alert.Ticks == 0 {
+		alert.Ticks = DEFAULT_DEPEG_TICKS
+	}
+	if alert.PriceLevel < 0 {
+		return errors.New("price_level must be a positive peg")
+	}
+	if alert.Threshold < 0 || alert.Threshold >= 10000 {
+		return errors.New("threshold must be a band between 0 and 10000 basis points")
+	}
+	if alert.Ticks < 1 || alert.Ticks > MAX_DEPEG_TICKS {
+		return fmt.Errorf("ticks must be between 1 and %d", MAX_DEPEG_TICKS)
+	}
+	return nil
+}
+
+// pegDeviation returns how far price is from peg, in basis points.
+func pegDeviation(price float64, peg float64) float64 {
+	return (price - peg) / peg * 10000
+}
+
+func evaluateDepegAlert(alert Alert, e evaluation) (reading, error) {
+	coinMapKey, coinInfo, err := lookupCoin(alert, e)
+	if err != nil {
+		return reading{}, err
+	}
+	price, err := strconv.ParseFloat(coinInfo.PriceUSD, 64)
+	if err != nil {
+		return reading{}, fmt.Errorf("no price_usd quote for %s", coinMapKey)
+	}
+
+	// The current tick is already stored, so the last Ticks snapshots are
+	// the ticks that must all be out of band.
+	prices, err := recentPrices(coinMapKey, alert.Ticks, e.Now)
+	if err != nil {
+		return reading{}, err
+	}
+	outside := 0
+	for _, p := range prices {
+		if math.Abs(pegDeviation(p, alert.PriceLevel)) <= alert.Threshold {
+			break
+		}
+		outside++
+	}
+
+	deviation := pegDeviation(price, alert.PriceLevel)
+	r := levelReading(math.Abs(deviation), alert.Threshold, DIRECTION_ABOVE, alert.Hysteresis)
+	r.Value = deviation
+	r.Violation = r.Violation && outside >= alert.Ticks
+	if r.Violation {
+		log.Debugf("Violation %s: price %f is %.1f bp from peg %f for %d ticks",
+			alert.CoinSymbol, price, deviation, alert.PriceLevel, outside)
+	}
+
+	r.Notification = coinNotification(coinInfo)
+	r.Notification.Metric = "peg_deviation_bp"
+	r.Notification.CurrentValue = price
+	r.Notification.ThresholdValue = alert.Threshold
+	r.Notification.Baseline = alert.PriceLevel
+	r.Notification.Currency = "USD"
+	r.Notification.Detail = fmt.Sprintf("%d consecutive ticks", outside)
+	return r, nil
+}
@@ -6,6 +6,9 @@ import (
 	"time"
 )
 
+func getSectionRow(title string) string {
+	return fmt.Sprintf("<h3>%s</h3>", title)
+}
 func getHeadingRow(field string, value string) string {
 	return fmt.Sprintf("<h4>%s: %s</h4>", field, value)
 }
@@ -15,6 +18,36 @@ func getStringRow(field string, value string) string {
 func getFloatRow(field string, value float64) string {
 	return fmt.Sprintf("<b>%s</b>: %.2f<br/>", field, value)
 }
+func getPriceRow(field string, value float64, currency string) string {
+	return fmt.Sprintf("<b>%s</b>: %s<br/>", field, formatPrice(value, currency))
+}
+
+// formatPrice shows fiat amounts to their minor unit and BTC amounts in satoshis.
+func formatPrice(value float64, currency string) string {
+	if (currency == "BTC") {
+		return fmt.Sprintf("%.8f %s", value, currency)
+	}
+	if (zeroDecimalCurrencies[currency]) {
+		return fmt.Sprintf("%.0f %s", value, currency)
+	}
+	return fmt.Sprintf("%.2f %s", value, currency)
+}
+// getPortfolioTable renders a compact table of a portfolio's positions.
+func getPortfolioTable(p PortfolioSummary) string {
+	rows := []string{"<tr><th>Coin</th><th>Quantity</th><th>Value</th><th>P&amp;L</th><th>Allocation</th></tr>"}
+	for _, position := range p.Positions {
+		rows = append(rows, fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td><td>%+.1f%%</td><td>%.1f%%</td></tr>",
+			position.Symbol, formatFloat(position.Quantity), formatPrice(position.Value, p.Currency),
+			position.PnLPercent, position.Allocation))
+	}
+	rows = append(rows, fmt.Sprintf("<tr><td><b>Total</b></td><td></td><td><b>%s</b></td><td>%+.1f%%</td><td>100%%</td></tr>",
+		formatPrice(p.Total, p.Currency), p.PnLPercent()))
+	return "<table cellpadding=\"4\">" + strings.Join(rows, "") + "</table>"
+}
+
+func formatRatio(value float64) string {
+	return fmt.Sprintf("%.6g", value)
+}
 //func getIntRow(field string, value int64) string {
 //	return fmt.Sprintf("<b>%s</b>: %s<br/>", field, value)
 //}
@@ -23,8 +56,139 @@ func msToTime(msInt int64) (time.Time, error) {
 	return time.Unix(0, msInt*1000*int64(time.Millisecond)), nil
 }
 
+// volatilityLevel is the move a volatility alert fires at, either way when
+// the alert has no direction.
+func volatilityLevel(n Notification) string {
+	if (n.Direction == "") {
+		return fmt.Sprintf("±%.2f%%", n.ThresholdValue * n.Baseline)
+	}
+	return fmt.Sprintf("%+.2f%%", n.ThresholdDelta)
+}
+
+func listingEventName(n Notification) string {
+	switch n.Direction {
+	case LISTING_LISTED:
+		return "New listing"
+	case LISTING_DELISTED:
+		return "Delisted"
+	}
+	return fmt.Sprintf("Entered the top %d", int(n.ThresholdValue))
+}
+
+// detailField is one labelled value describing a notification. Emails
+// render fields as rows; text and chat channels render them their own way.
+type detailField struct {
+	Name  string
+	Value string
+}
+
+func stringField(name string, value string) detailField {
+	return detailField{name, value}
+}
+func floatField(name string, value float64) detailField {
+	return detailField{name, fmt.Sprintf("%.2f", value)}
+}
+func priceField(name string, value float64, currency string) detailField {
+	return detailField{name, formatPrice(value, currency)}
+}
+
+// fxFields shows the exchange rate a notification was converted at, if any.
+func fxFields(n Notification) []detailField {
+	if (n.FXRate == 0 || n.FXRatesAt == nil) {
+		return nil
+	}
+	return []detailField{stringField("Exchange Rate", fmt.Sprintf("1 USD = %.4f %s (%s, as of %s)",
+		n.FXRate, n.Currency, n.FXSource, n.FXRatesAt.UTC().Format("2006-01-02 15:04 MST")))}
+}
+
+// notificationFields describes what triggered a notification.
+func notificationFields(n Notification) []detailField {
+	switch n.Kind {
+	case ALERT_KIND_PRICE:
+		return append([]detailField{priceField("Current Price", n.CurrentValue, n.Currency),
+			stringField("Alert Level", n.Direction + " " + formatPrice(n.ThresholdValue, n.Currency))},
+			fxFields(n)...)
+	case ALERT_KIND_VOLUME:
+		return []detailField{priceField("24h Volume", n.CurrentValue, n.Currency),
+			priceField(fmt.Sprintf("Average Volume (%s)", n.TimeDelta), n.Baseline, n.Currency),
+			stringField("Volume Spike", fmt.Sprintf("%.2fx average (threshold %.2fx)", n.CurrentValue / n.Baseline, n.ThresholdValue))}
+	case ALERT_KIND_MARKET_CAP:
+		return append([]detailField{priceField("Market Cap", n.CurrentValue, n.Currency),
+			stringField("Alert Level", n.Direction + " " + formatPrice(n.ThresholdValue, n.Currency))},
+			fxFields(n)...)
+	case ALERT_KIND_RANK:
+		return []detailField{stringField("Rank", fmt.Sprintf("%d", int(n.CurrentValue))),
+			stringField("Alert Level", fmt.Sprintf("%s top %d", n.Direction, int(n.ThresholdValue)))}
+	case ALERT_KIND_DEPEG:
+		return []detailField{priceField("Current Price", n.CurrentValue, n.Currency),
+			stringField("Deviation From Peg", fmt.Sprintf("%+.1f bp from %s (band ±%s bp)",
+				pegDeviation(n.CurrentValue, n.Baseline), formatPrice(n.Baseline, n.Currency), formatFloat(n.ThresholdValue))),
+			stringField("Outside Band For", n.Detail)}
+	case ALERT_KIND_VOLATILITY:
+		return []detailField{stringField(fmt.Sprintf("Move (%s)", n.TimeDelta), fmt.Sprintf("%+.2f%% (%+.2f sigma)", n.CurrentDelta, n.CurrentValue)),
+			stringField("Alert Level", fmt.Sprintf("%s sigma, a move of %s (1 sigma = %.2f%% over %s)",
+				formatFloat(n.ThresholdValue), volatilityLevel(n), n.Baseline, n.Detail))}
+	case ALERT_KIND_INDICATOR:
+		return []detailField{stringField("Indicator", n.Metric + " " + n.Direction),
+			stringField("Indicator Values", n.Detail)}
+	case ALERT_KIND_LISTING:
+		return []detailField{stringField("Event", listingEventName(n)),
+			stringField("Coins", n.Detail)}
+	case ALERT_KIND_PORTFOLIO_VALUE:
+		return append([]detailField{priceField("Portfolio Value", n.CurrentValue, n.Currency),
+			stringField("Alert Level", n.Direction + " " + formatPrice(n.ThresholdValue, n.Currency))},
+			fxFields(n)...)
+	case ALERT_KIND_POSITION_PNL:
+		return []detailField{priceField("Position Value", n.CurrentValue, n.Currency),
+			floatField("Current % P&L", n.CurrentDelta),
+			floatField("Threshold % P&L", n.ThresholdDelta)}
+	case ALERT_KIND_ALLOCATION_DRIFT:
+		return []detailField{stringField("Largest Drift", fmt.Sprintf("%.1f points (threshold %s)", n.CurrentValue, formatFloat(n.ThresholdValue))),
+			stringField("Off Target", n.Detail)}
+	case ALERT_KIND_MARKET:
+		if (n.TimeDelta != "") {
+			return []detailField{stringField("Current Value", formatMarketMetric(n.Metric, n.CurrentValue)),
+				stringField(fmt.Sprintf("Value %s ago", n.TimeDelta), formatMarketMetric(n.Metric, n.Baseline)),
+				stringField("Change", formatMarketChange(n.Metric, n.CurrentDelta)),
+				stringField("Threshold", formatMarketChange(n.Metric, n.ThresholdDelta)),
+				stringField("Market", n.Detail)}
+		}
+		return []detailField{stringField("Current Value", formatMarketMetric(n.Metric, n.CurrentValue)),
+			stringField("Alert Level", n.Direction + " " + formatMarketMetric(n.Metric, n.ThresholdValue)),
+			stringField("Market", n.Detail)}
+	case ALERT_KIND_SPREAD:
+		return []detailField{stringField("Spread", fmt.Sprintf("%+.3f%%", n.CurrentValue)),
+			stringField("Alert Level", fmt.Sprintf("%s%% either way", formatFloat(n.ThresholdValue))),
+			stringField("Quotes", n.Detail)}
+	case ALERT_KIND_COMPOSITE:
+		return []detailField{stringField("Rule", n.Metric),
+			stringField("Observed", n.Detail)}
+	case ALERT_KIND_PAIR:
+		ratio := stringField("Current Ratio", formatRatio(n.CurrentValue))
+		if (n.TimeDelta != "") {
+			return []detailField{ratio, stringField(fmt.Sprintf("Ratio %s ago", n.TimeDelta), formatRatio(n.Baseline)),
+				floatField("Current % Change", n.CurrentDelta),
+				floatField("Threshold % Change", n.ThresholdDelta)}
+		}
+		return []detailField{ratio, stringField("Alert Level", n.Direction + " " + formatRatio(n.ThresholdValue))}
+	default:
+		return []detailField{floatField("Current % Change", n.CurrentDelta),
+			floatField("Threshold % Change", n.ThresholdDelta)}
+	}
+}
+
+// notificationDetail renders the rows describing what triggered a notification.
+func notificationDetail(n Notification) string {
+	var rows []string
+	for _, f := range notificationFields(n) {
+		rows = append(rows, getStringRow(f.Name, f.Value))
+	}
+	return strings.Join(rows, "")
+}
+
 func prettyPrintNotifications(alertNames []string, ns []Notification) string {
 	var s []string
+	var depegs []string
 	for i := range ns {
 		n := ns[i]
 		alertName := alertNames[i]
@@ -37,10 +201,29 @@ func prettyPrintNotifications(alertNames []string, ns []Notification) string {
 			getHeadingRow("Alert Name", alertName),
 			getStringRow("Coin Name", n.CoinName),
 			getStringRow("Coin Symbol", n.CoinSymbol),
-			getFloatRow("Current % Change", n.CurrentDelta),
-			getFloatRow("Threshold % Change", n.ThresholdDelta),
-			getStringRow("As of time", dateUpdated.UTC().String()))
-		s=append(s, nString)
+			notificationDetail(n),
+			getStringRow("As of time", dateUpdated.UTC().String()),
+			getStringRow("Next alert no sooner than", formatCooldown(n.CooldownMinutes)))
+		if (n.Kind == ALERT_KIND_DEPEG) {
+			depegs = append(depegs, nString)
+		} else {
+			s=append(s, nString)
+		}
+	}
+	// Portfolio alerts share one summary of the holdings they were evaluated on.
+	for _, n := range ns {
+		if (n.Portfolio != nil) {
+			s = append(s, getSectionRow("Portfolio Summary") + getPortfolioTable(*n.Portfolio))
+			break
+		}
+	}
+	// Stablecoin de-pegs get their own section at the top of the email.
+	if (len(depegs) > 0) {
+		section := getSectionRow("Stablecoin De-peg Alerts") + strings.Join(depegs, "<br/><hr/><br/>")
+		if (len(s) > 0) {
+			section += getSectionRow("Other Alerts")
+		}
+		s = append([]string{section}, s...)
 	}
 	return strings.Join(s, "<br/><hr/><br/>")
 }
@@ -82,7 +265,7 @@ func createEmailBodyFromNotifications(alertNames []string, ns []Notification) st
 								</tr>
 									<tr style="font-family: 'Helvetica Neue',Helvetica,Arial,sans-serif; box-sizing: border-box; font-size: 14px; margin: 0;">
 								<td class="content-block" style="font-family: 'Helvetica Neue',Helvetica,Arial,sans-serif; box-sizing: border-box; font-size: 14px; vertical-align: top; margin: 0; padding: 0 0 20px;" valign="top">
-                                        You will not be alerted on these currencies again for at least the next 12 hours.
+                                        You will not be alerted by these alerts again until their cooldown (shown above) has passed.
 								</td>
 								</tr>
 
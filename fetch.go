@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FetchPolicy bounds how long and how often a provider is queried per cycle.
+type FetchPolicy struct {
+	Timeout          time.Duration
+	Retries          int
+	Backoff          time.Duration // initial retry delay, doubled after each attempt.
+	BreakerThreshold int           // consecutive failed cycles before the breaker opens.
+	BreakerCooldown  time.Duration
+	MaxQuoteAge      time.Duration // quotes older than this never trigger notifications.
+}
+
+var fetchPolicy = FetchPolicy{
+	Timeout:          10 * time.Second,
+	Retries:          3,
+	Backoff:          time.Second,
+	BreakerThreshold: 3,
+	BreakerCooldown:  time.Hour,
+	MaxQuoteAge:      2 * time.Hour,
+}
+
+const (
+	FETCH_TIMEOUT_ENV = "FETCH_TIMEOUT"
+	FETCH_RETRIES_ENV = "FETCH_RETRIES"
+	MAX_QUOTE_AGE_ENV = "MAX_QUOTE_AGE"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+func configureFetchPolicy() {
+	if v := os.Getenv(FETCH_TIMEOUT_ENV); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			fetchPolicy.Timeout = d
+		} else {
+			log.Errorf("invalid %s: %s", FETCH_TIMEOUT_ENV, v)
+		}
+	}
+	if v := os.Getenv(FETCH_RETRIES_ENV); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			fetchPolicy.Retries = n
+		} else {
+			log.Errorf("invalid %s: %s", FETCH_RETRIES_ENV, v)
+		}
+	}
+	if v := os.Getenv(MAX_QUOTE_AGE_ENV); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			fetchPolicy.MaxQuoteAge = d
+		} else {
+			log.Errorf("invalid %s: %s", MAX_QUOTE_AGE_ENV, v)
+		}
+	}
+}
+
+// circuitBreaker stops querying a provider after repeated failed cycles
+// until the cooldown has elapsed.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.openUntil)
+}
+
+func (b *circuitBreaker) record(err error, now time.Time, policy FetchPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	// Failures are only reset by a success, so a single failed trial after
+	// the cooldown reopens the breaker.
+	b.failures++
+	if b.failures >= policy.BreakerThreshold {
+		b.openUntil = now.Add(policy.BreakerCooldown)
+	}
+}
+
+// guardedProvider wraps a PriceProvider with retries and a circuit breaker.
+type guardedProvider struct {
+	PriceProvider
+	breaker circuitBreaker
+	sleep   func(time.Duration)
+}
+
+func newGuardedProvider(provider PriceProvider) *guardedProvider {
+	return &guardedProvider{PriceProvider: provider, sleep: time.Sleep}
+}
+
+func (p *guardedProvider) FetchPrices() (map[string]CoinInfo, error) {
+	if !p.breaker.allow(time.Now()) {
+		return nil, ErrCircuitOpen
+	}
+
+	var prices map[string]CoinInfo
+	var err error
+	backoff := fetchPolicy.Backoff
+	for attempt := 0; attempt <= fetchPolicy.Retries; attempt++ {
+		if attempt > 0 {
+			log.Debugf("retrying %s in %s (attempt %d): %s", p.Name(), backoff, attempt, err.Error())
+			p.sleep(backoff)
+			backoff *= 2
+		}
+		prices, err = p.PriceProvider.FetchPrices()
+		if err == nil {
+			break
+		}
+	}
+	if err == nil && len(prices) == 0 {
+		err = errors.New("empty ticker response")
+	}
+
+	p.breaker.record(err, time.Now(), fetchPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", p.Name(), err.Error())
+	}
+	return prices, nil
+}
+
+// isStaleQuote reports whether a quote is older than maxAge, treating a
+// missing or unparseable timestamp as stale.
+func isStaleQuote(coinInfo CoinInfo, maxAge time.Duration, now time.Time) bool {
+	lastUpdated, err := strconv.ParseInt(coinInfo.LastUpdated, 10, 64)
+	if err != nil {
+		return true
+	}
+	return now.Sub(time.Unix(lastUpdated, 0)) > maxAge
+}
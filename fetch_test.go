@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type flakyProvider struct {
+	calls    int
+	failures int
+}
+
+func (p *flakyProvider) Name() string {
+	return "flaky"
+}
+
+func (p *flakyProvider) FetchPrices() (map[string]CoinInfo, error) {
+	p.calls++
+	if p.calls <= p.failures {
+		return nil, errors.New("upstream unavailable")
+	}
+	return map[string]CoinInfo{"BTC_BITCOIN": {PriceUSD: "100"}}, nil
+}
+
+func TestGuardedProviderRetries(t *testing.T) {
+	inner := &flakyProvider{failures: 2}
+	p := newGuardedProvider(inner)
+	var delays []time.Duration
+	p.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	prices, err := p.FetchPrices()
+	if assert.NoError(t, err) {
+		assert.Len(t, prices, 1)
+	}
+	assert.Equal(t, 3, inner.calls)
+	assert.Equal(t, []time.Duration{fetchPolicy.Backoff, 2 * fetchPolicy.Backoff}, delays)
+}
+
+func TestGuardedProviderCircuitBreaker(t *testing.T) {
+	inner := &flakyProvider{failures: 1000}
+	p := newGuardedProvider(inner)
+	p.sleep = func(time.Duration) {}
+
+	for i := 0; i < fetchPolicy.BreakerThreshold; i++ {
+		_, err := p.FetchPrices()
+		assert.Error(t, err)
+	}
+	calls := inner.calls
+
+	_, err := p.FetchPrices()
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, calls, inner.calls)
+}
+
+func TestIsStaleQuote(t *testing.T) {
+	now := time.Now()
+	fresh := CoinInfo{LastUpdated: strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)}
+	old := CoinInfo{LastUpdated: strconv.FormatInt(now.Add(-3*time.Hour).Unix(), 10)}
+
+	assert.False(t, isStaleQuote(fresh, 2*time.Hour, now))
+	assert.True(t, isStaleQuote(old, 2*time.Hour, now))
+	assert.True(t, isStaleQuote(CoinInfo{}, 2*time.Hour, now))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// FX provider configuration. FX_PROVIDER is "http" or "file" (the default)
+// and FX_RATES_URL the endpoint or path of a rates document.
+const (
+	FX_PROVIDER_ENV  = "FX_PROVIDER"
+	FX_RATES_URL_ENV = "FX_RATES_URL"
+)
+
+// Quote currencies for prices and thresholds besides USD and BTC.
+var FIAT_CURRENCIES = map[string]bool{
+	"USD": true, "EUR": true, "GBP": true, "JPY": true, "CHF": true, "CAD": true, "AUD": true,
+	"NZD": true, "CNY": true, "HKD": true, "SGD": true, "KRW": true, "INR": true, "BRL": true,
+	"MXN": true, "SEK": true, "NOK": true, "DKK": true, "PLN": true, "ZAR": true, "TRY": true,
+}
+
+// Alert kinds whose values are quoted in PriceCurrency, which defaults to the
+// user's currency. Volume and spread alerts only show their amounts in it,
+// since their thresholds are multiples and percents.
+var quoteCurrencyKinds = map[string]bool{
+	ALERT_KIND_PRICE: true, ALERT_KIND_MARKET_CAP: true,
+	ALERT_KIND_PORTFOLIO_VALUE: true, ALERT_KIND_POSITION_PNL: true, ALERT_KIND_ALLOCATION_DRIFT: true,
+	ALERT_KIND_VOLUME: true, ALERT_KIND_SPREAD: true,
+}
+
+// Currencies quoted without minor units.
+var zeroDecimalCurrencies = map[string]bool{"JPY": true, "KRW": true}
+
+// FXRates are units of each currency per 1 USD.
+type FXRates struct {
+	Source string
+	AsOf   time.Time
+	Rates  map[string]float64
+}
+
+// FXProvider fetches fiat exchange rates.
+type FXProvider interface {
+	Name() string
+	FetchRates() (FXRates, error)
+}
+
+// FXConfig describes the configured FX rate source.
+type FXConfig struct {
+	Kind string
+	URL  string
+}
+
+var fxRegistry = map[string]func(FXConfig) FXProvider{
+	"http": func(c FXConfig) FXProvider { return &httpFXProvider{c} },
+	"file": func(c FXConfig) FXProvider { return &fileFXProvider{c} },
+}
+
+// fxProvider is nil when no rates are configured, in which case only USD
+// and BTC quotes can be evaluated.
+var fxProvider FXProvider
+
+func newFXProvider(config FXConfig) (FXProvider, error) {
+	factory, ok := fxRegistry[config.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown FX provider kind %q", config.Kind)
+	}
+	return factory(config), nil
+}
+
+func configureFX() {
+	config := FXConfig{Kind: os.Getenv(FX_PROVIDER_ENV), URL: os.Getenv(FX_RATES_URL_ENV)}
+	if config.URL == "" {
+		log.Debug("no FX rates configured, prices are USD and BTC only")
+		return
+	}
+	if config.Kind == "" {
+		config.Kind = "file"
+	}
+	p, err := newFXProvider(config)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	fxProvider = p
+	log.Debugf("FX rates from %s %s", config.Kind, config.URL)
+}
+
+// fxDocument is the rates format read by both FX providers, e.g.
+// {"base": "USD", "timestamp": 1709294400, "rates": {"EUR": 0.92}}.
+type fxDocument struct {
+	Base      string             `json:"base"`
+	Timestamp int64              `json:"timestamp"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+// usdRates rebases a rates document on USD.
+func (d fxDocument) usdRates(source string) (FXRates, error) {
+	base := strings.ToUpper(d.Base)
+	if base == "" {
+		base = "USD"
+	}
+	rates := make(map[string]float64)
+	for currency, rate := range d.Rates {
+		rates[strings.ToUpper(currency)] = rate
+	}
+	rates[base] = 1
+
+	usd := rates["USD"]
+	if usd <= 0 {
+		return FXRates{}, fmt.Errorf("%s has no USD rate", source)
+	}
+	for currency, rate := range rates {
+		rates[currency] = rate / usd
+	}
+	asOf := time.Now()
+	if d.Timestamp > 0 {
+		asOf = time.Unix(d.Timestamp, 0)
+	}
+	return FXRates{Source: source, AsOf: asOf, Rates: rates}, nil
+}
+
+type httpFXProvider struct {
+	config FXConfig
+}
+
+func (p *httpFXProvider) Name() string {
+	return p.config.URL
+}
+
+func (p *httpFXProvider) FetchRates() (FXRates, error) {
+	var d fxDocument
+	if err := getJSON(p.config.URL, &d); err != nil {
+		return FXRates{}, err
+	}
+	return d.usdRates(p.Name())
+}
+
+// fileFXProvider reads rates from a local file, standing in for a rates API.
+type fileFXProvider struct {
+	config FXConfig
+}
+
+func (p *fileFXProvider) Name() string {
+	return "file:" + p.config.URL
+}
+
+func (p *fileFXProvider) FetchRates() (FXRates, error) {
+	b, err := ioutil.ReadFile(p.config.URL)
+	if err != nil {
+		return FXRates{}, err
+	}
+	var d fxDocument
+	if err := json.Unmarshal(b, &d); err != nil {
+		return FXRates{}, err
+	}
+	return d.usdRates(p.Name())
+}
+
+// fetchFXRates returns the current rates, or USD alone when no provider is
+// configured or it fails.
+func fetchFXRates() FXRates {
+	usdOnly := FXRates{Rates: map[string]float64{"USD": 1}}
+	if fxProvider == nil {
+		return usdOnly
+	}
+	rates, err := fxProvider.FetchRates()
+	if err != nil {
+		log.Errorf("could not fetch FX rates from %s: %s", fxProvider.Name(), err.Error())
+		return usdOnly
+	}
+	return rates
+}
+
+// convertUSD converts a USD amount into currency, returning the rate used.
+// An empty currency is USD, as on alerts created before quote currencies.
+func (r FXRates) convertUSD(amount float64, currency string) (float64, float64, error) {
+	if currency == "" || currency == "USD" {
+		return amount, 1, nil
+	}
+	rate, ok := r.Rates[currency]
+	if !ok || rate <= 0 {
+		return 0, 0, fmt.Errorf("no FX rate for %s", currency)
+	}
+	return amount * rate, rate, nil
+}
+
+// displayRate returns the currency and rate to show USD amounts in when the
+// alert does not depend on them, falling back to USD when there is no rate.
+func (r FXRates) displayRate(currency string) (string, float64) {
+	if currency == "" {
+		return "USD", 1
+	}
+	if _, rate, err := r.convertUSD(1, currency); err == nil {
+		return currency, rate
+	}
+	log.Errorf("no FX rate for %s, showing USD", currency)
+	return "USD", 1
+}
+
+// userCurrency returns the quote currency set on the user's account, or USD.
+func userCurrency(email string) string {
+	var user User
+	if err := db.Where("email = ?", email).First(&user).Error; err == nil && user.Currency != "" {
+		return user.Currency
+	}
+	return "USD"
+}
+
+// saveUserCurrency sets the default quote currency on a user's account,
+// creating the account if needed.
+func saveUserCurrency(email string, currency string) error {
+	var user User
+	if err := db.Where(User{Email: email}).FirstOrInit(&user).Error; err != nil {
+		return err
+	}
+	user.Currency = currency
+	return db.Save(&user).Error
+}
+
+// validateQuoteCurrency normalizes currency, accepting BTC only when allowBTC.
+func validateQuoteCurrency(currency *string, allowBTC bool) error {
+	*currency = strings.ToUpper(*currency)
+	if *currency == "" {
+		*currency = "USD"
+	}
+	if FIAT_CURRENCIES[*currency] || (allowBTC && *currency == "BTC") {
+		return nil
+	}
+	return fmt.Errorf("unsupported price_currency %q", *currency)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fxFixture = `{"base":"EUR","timestamp":1709294400,"rates":{"USD":1.08,"GBP":0.855,"JPY":162.0}}`
+
+func TestFileFXProviderRebasesOnUSD(t *testing.T) {
+	f, err := ioutil.TempFile("", "fx")
+	check(err)
+	defer os.Remove(f.Name())
+	f.WriteString(fxFixture)
+	f.Close()
+
+	p, err := newFXProvider(FXConfig{Kind: "file", URL: f.Name()})
+	assert.NoError(t, err)
+	rates, err := p.FetchRates()
+	if assert.NoError(t, err) {
+		assert.InDelta(t, 1, rates.Rates["USD"], 1e-9)
+		assert.InDelta(t, 0.925926, rates.Rates["EUR"], 1e-6)
+		assert.InDelta(t, 150, rates.Rates["JPY"], 1e-9)
+		assert.Equal(t, int64(1709294400), rates.AsOf.Unix())
+	}
+}
+
+func TestHTTPFXProvider(t *testing.T) {
+	server := stubServer(`{"base":"USD","rates":{"EUR":0.92}}`)
+	defer server.Close()
+
+	p, _ := newFXProvider(FXConfig{Kind: "http", URL: server.URL})
+	rates, err := p.FetchRates()
+	if assert.NoError(t, err) {
+		amount, rate, err := rates.convertUSD(100, "EUR")
+		assert.NoError(t, err)
+		assert.InDelta(t, 92, amount, 1e-9)
+		assert.Equal(t, 0.92, rate)
+		_, _, err = rates.convertUSD(100, "GBP")
+		assert.Error(t, err)
+	}
+}
+
+func TestEvaluatePriceAlertInEUR(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_PRICE, CoinSymbol: "BTC", CoinName: "Bitcoin", PriceLevel: 55000, Direction: DIRECTION_ABOVE,
+		PriceCurrency: "eur"}
+	assert.NoError(t, validateAlert(&alert))
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_PRICE, PriceLevel: 1, Direction: DIRECTION_ABOVE, PriceCurrency: "XYZ"}))
+
+	e := testEvaluation(CoinInfo{Symbol: "BTC", Name: "Bitcoin", PriceUSD: "60000"})
+	e.FX = FXRates{Source: "test", Rates: map[string]float64{"USD": 1, "EUR": 0.92}}
+	r, err := evaluateAlert(alert, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, "price_eur", r.Notification.Metric)
+		assert.InDelta(t, 55200, r.Notification.CurrentValue, 1e-6)
+		assert.Equal(t, 0.92, r.Notification.FXRate)
+		assert.Contains(t, notificationDetail(r.Notification), "<b>Exchange Rate</b>: 1 USD = 0.9200 EUR (test, as of")
+	}
+
+	e.FX = FXRates{Rates: map[string]float64{"USD": 1}}
+	_, err = evaluateAlert(alert, e)
+	assert.Error(t, err, "no EUR rate")
+}
+
+func TestDisplayRate(t *testing.T) {
+	rates := FXRates{Rates: map[string]float64{"USD": 1, "EUR": 0.92}}
+	currency, rate := rates.displayRate("EUR")
+	assert.Equal(t, "EUR", currency)
+	assert.Equal(t, 0.92, rate)
+	for _, c := range []string{"", "USD", "GBP"} {
+		currency, rate = rates.displayRate(c)
+		assert.Equal(t, "USD", currency, c)
+		assert.Equal(t, 1.0, rate, c)
+	}
+}
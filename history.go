@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rows per INSERT statement when writing snapshots.
+const SNAPSHOT_BATCH_SIZE = 500
+
+const snapshotColumns = "created_at, updated_at, coin_key, coin_symbol, coin_name, price_usd, price_btc, volume24, market_cap_usd, rank, taken_at"
+
+func parseCoinFloat(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+func snapshotsFromPrices(CoinDeltas map[string]CoinInfo, takenAt time.Time) []PriceSnapshot {
+	var snapshots []PriceSnapshot
+	for key, coinInfo := range CoinDeltas {
+		rank, _ := strconv.Atoi(coinInfo.Rank)
+		snapshots = append(snapshots, PriceSnapshot{
+			CoinKey: key, CoinSymbol: coinInfo.Symbol, CoinName: coinInfo.Name,
+			PriceUSD: parseCoinFloat(coinInfo.PriceUSD), PriceBTC: parseCoinFloat(coinInfo.PriceBTC),
+			Volume24: parseCoinFloat(coinInfo.Volume24), MarketCapUSD: parseCoinFloat(coinInfo.MarketCapUSD),
+			Rank: rank, TakenAt: takenAt,
+		})
+	}
+	return snapshots
+}
+
+// snapshotBatches splits snapshots into groups of at most SNAPSHOT_BATCH_SIZE.
+func snapshotBatches(snapshots []PriceSnapshot) [][]PriceSnapshot {
+	var batches [][]PriceSnapshot
+	for start := 0; start < len(snapshots); start += SNAPSHOT_BATCH_SIZE {
+		batches = append(batches, snapshots[start:min(start+SNAPSHOT_BATCH_SIZE, len(snapshots))])
+	}
+	return batches
+}
+
+// snapshotInsertSQL builds one multi-row INSERT for a batch of snapshots.
+func snapshotInsertSQL(batch []PriceSnapshot, now time.Time) (string, []interface{}) {
+	var rows []string
+	var values []interface{}
+	for _, s := range batch {
+		rows = append(rows, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		values = append(values, now, now, s.CoinKey, s.CoinSymbol, s.CoinName,
+			s.PriceUSD, s.PriceBTC, s.Volume24, s.MarketCapUSD, s.Rank, s.TakenAt)
+	}
+	return "INSERT INTO price_snapshots (" + snapshotColumns + ") VALUES " + strings.Join(rows, ", "), values
+}
+
+// insertSnapshots writes snapshots using multi-row inserts, since gorm only
+// creates one row per statement.
+func insertSnapshots(snapshots []PriceSnapshot) error {
+	now := time.Now()
+	for _, batch := range snapshotBatches(snapshots) {
+		sql, values := snapshotInsertSQL(batch, now)
+		if err := db.Exec(sql, values...).Error; err != nil {
+			return err
+		}
+	}
+	log.Debugf("stored %d price snapshots", len(snapshots))
+	return nil
+}
+
+// averageVolume returns the mean 24h volume stored for a coin in [from, to)
+// and the number of snapshots it was computed from.
+func averageVolume(coinKey string, from time.Time, to time.Time) (float64, int, error) {
+	var result struct {
+		Average float64
+		Samples int
+	}
+	err := db.Table("price_snapshots").
+		Select("COALESCE(AVG(volume24), 0) AS average, COUNT(*) AS samples").
+		Where("coin_key = ? AND taken_at >= ? AND taken_at < ? AND volume24 > 0", coinKey, from, to).
+		Scan(&result).Error
+	return result.Average, result.Samples, err
+}
+
+// closingPrices returns up to count USD closes for a coin, one per interval
+// ending at now, oldest first. Each close is the last snapshot in its
+// interval; intervals without snapshots are skipped.
+func closingPrices(coinKey string, interval time.Duration, count int, now time.Time) ([]float64, error) {
+	from := now.Add(-interval * time.Duration(count))
+	var snapshots []PriceSnapshot
+	err := db.Select("price_usd, taken_at").
+		Where("coin_key = ? AND taken_at > ? AND taken_at <= ? AND price_usd > 0", coinKey, from, now).
+		Order("taken_at").Find(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+
+	closes := make([]float64, count)
+	present := make([]bool, count)
+	for _, s := range snapshots {
+		i := int(s.TakenAt.Sub(from) / interval)
+		if i >= count {
+			i = count - 1
+		}
+		closes[i] = s.PriceUSD
+		present[i] = true
+	}
+
+	var series []float64
+	for i := range closes {
+		if present[i] {
+			series = append(series, closes[i])
+		}
+	}
+	return series, nil
+}
+
+// recentPrices returns the USD prices from the last count snapshots of a coin
+// taken at or before now, newest first.
+func recentPrices(coinKey string, count int, now time.Time) ([]float64, error) {
+	var snapshots []PriceSnapshot
+	err := db.Select("price_usd").Where("coin_key = ? AND taken_at <= ?", coinKey, now).
+		Order("taken_at desc").Limit(count).Find(&snapshots).Error
+	prices := make([]float64, len(snapshots))
+	for i, s := range snapshots {
+		prices[i] = s.PriceUSD
+	}
+	return prices, err
+}
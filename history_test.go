@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotsFromPrices(t *testing.T) {
+	takenAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		key      string
+		coinInfo CoinInfo
+		expected PriceSnapshot
+	}{
+		{"all fields", "BTC_BITCOIN",
+			CoinInfo{Symbol: "BTC", Name: "Bitcoin", PriceUSD: "70250.5", PriceBTC: "1", Volume24: "3.5e10",
+				MarketCapUSD: "1380000000000", Rank: "1"},
+			PriceSnapshot{CoinKey: "BTC_BITCOIN", CoinSymbol: "BTC", CoinName: "Bitcoin", PriceUSD: 70250.5, PriceBTC: 1,
+				Volume24: 3.5e10, MarketCapUSD: 1.38e12, Rank: 1, TakenAt: takenAt}},
+		{"missing fields", "ETH_ETHEREUM",
+			CoinInfo{Symbol: "ETH", Name: "Ethereum"},
+			PriceSnapshot{CoinKey: "ETH_ETHEREUM", CoinSymbol: "ETH", CoinName: "Ethereum", TakenAt: takenAt}},
+		{"unparsable fields", "DOGE_DOGECOIN",
+			CoinInfo{Symbol: "DOGE", Name: "Dogecoin", PriceUSD: "n/a", PriceBTC: "-", Volume24: "1,000",
+				MarketCapUSD: "null", Rank: "first"},
+			PriceSnapshot{CoinKey: "DOGE_DOGECOIN", CoinSymbol: "DOGE", CoinName: "Dogecoin", TakenAt: takenAt}},
+	}
+	for _, test := range tests {
+		snapshots := snapshotsFromPrices(map[string]CoinInfo{test.key: test.coinInfo}, takenAt)
+		if assert.Len(t, snapshots, 1, test.name) {
+			assert.Equal(t, test.expected, snapshots[0], test.name)
+		}
+	}
+	assert.Empty(t, snapshotsFromPrices(map[string]CoinInfo{}, takenAt))
+}
+
+func TestSnapshotBatches(t *testing.T) {
+	tests := map[int][]int{
+		0:                           nil,
+		1:                           {1},
+		SNAPSHOT_BATCH_SIZE:         {SNAPSHOT_BATCH_SIZE},
+		SNAPSHOT_BATCH_SIZE + 1:     {SNAPSHOT_BATCH_SIZE, 1},
+		2*SNAPSHOT_BATCH_SIZE + 250: {SNAPSHOT_BATCH_SIZE, SNAPSHOT_BATCH_SIZE, 250},
+	}
+	for count, expected := range tests {
+		snapshots := make([]PriceSnapshot, count)
+		for i := range snapshots {
+			snapshots[i].Rank = i
+		}
+		var sizes []int
+		next := 0
+		for _, batch := range snapshotBatches(snapshots) {
+			sizes = append(sizes, len(batch))
+			for _, s := range batch {
+				assert.Equal(t, next, s.Rank, "snapshots are kept in order")
+				next++
+			}
+		}
+		assert.Equal(t, expected, sizes, "%d snapshots", count)
+	}
+}
+
+func TestSnapshotInsertSQL(t *testing.T) {
+	now := time.Now()
+	takenAt := now.Add(-time.Minute)
+	sql, values := snapshotInsertSQL([]PriceSnapshot{
+		{CoinKey: "BTC_BITCOIN", CoinSymbol: "BTC", CoinName: "Bitcoin", PriceUSD: 100, Rank: 1, TakenAt: takenAt},
+		{CoinKey: "ETH_ETHEREUM", CoinSymbol: "ETH", CoinName: "Ethereum", PriceUSD: 10, Rank: 2, TakenAt: takenAt},
+	}, now)
+	assert.True(t, strings.HasPrefix(sql, "INSERT INTO price_snapshots ("+snapshotColumns+") VALUES "))
+	assert.Equal(t, 2, strings.Count(sql, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"))
+	assert.Equal(t, strings.Count(sql, "?"), len(values))
+	assert.Equal(t, []interface{}{now, now, "BTC_BITCOIN", "BTC", "Bitcoin", 100.0, 0.0, 0.0, 0.0, 1, takenAt}, values[:11])
+	assert.Equal(t, "ETH_ETHEREUM", values[13])
+}
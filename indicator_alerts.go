@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cbonoz/crypto-go/indicators"
+)
+
+// ALERT_KIND_INDICATOR fires on technical indicators computed from stored
+// price history. Direction is "above" or "below": the fast average crossing
+// above/below the slow one, RSI above/below its level, or the price breaking
+// above the upper/below the lower Bollinger band.
+const ALERT_KIND_INDICATOR = "indicator"
+
+const (
+	INDICATOR_SMA_CROSS = "sma_cross"
+	INDICATOR_EMA_CROSS = "ema_cross"
+	INDICATOR_RSI       = "rsi"
+	INDICATOR_BOLLINGER = "bollinger"
+)
+
+const (
+	DEFAULT_INDICATOR_INTERVAL = "1h"
+	MIN_INDICATOR_INTERVAL     = 30 * time.Minute // the scheduler tick.
+	MAX_INDICATOR_PERIOD       = 200
+	// RSI uses extra history so Wilder's smoothing has warmed up.
+	RSI_HISTORY_FACTOR = 3
+)
+
+// IndicatorSpec configures an indicator alert. It is stored as JSON on the alert.
+type IndicatorSpec struct {
+	Type       string  `json:"type"`
+	Interval   string  `json:"interval"` // candle size, e.g. "1h" or "1d".
+	Fast       int     `json:"fast,omitempty"`
+	Slow       int     `json:"slow,omitempty"`
+	Period     int     `json:"period,omitempty"`
+	Level      float64 `json:"level,omitempty"`      // RSI level.
+	Deviations float64 `json:"deviations,omitempty"` // Bollinger band width in standard deviations.
+}
+
+func init() {
+	registerAlertKind(ALERT_KIND_INDICATOR, alertKind{validateIndicatorAlert, evaluateIndicatorAlert})
+}
+
+func (s IndicatorSpec) Value() (driver.Value, error) {
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+func (s *IndicatorSpec) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	}
+	return fmt.Errorf("cannot scan %T into IndicatorSpec", src)
+}
+
+func (s IndicatorSpec) String() string {
+	switch s.Type {
+	case INDICATOR_SMA_CROSS, INDICATOR_EMA_CROSS:
+		return fmt.Sprintf("%s(%d/%d, %s)", s.Type, s.Fast, s.Slow, s.Interval)
+	case INDICATOR_BOLLINGER:
+		return fmt.Sprintf("%s(%d, %sσ, %s)", s.Type, s.Period, formatFloat(s.Deviations), s.Interval)
+	}
+	return fmt.Sprintf("%s(%d, %s)", s.Type, s.Period, s.Interval)
+}
+
+// candles is the number of intervals of history the indicator needs.
+func (s IndicatorSpec) candles() int {
+	switch s.Type {
+	case INDICATOR_SMA_CROSS, INDICATOR_EMA_CROSS:
+		return s.Slow
+	case INDICATOR_RSI:
+		return s.Period * RSI_HISTORY_FACTOR
+	}
+	return s.Period
+}
+
+func validatePeriod(name string, period int) error {
+	if period < 1 || period > MAX_INDICATOR_PERIOD {
+		return fmt.Errorf("%s must be between 1 and %d", name, MAX_INDICATOR_PERIOD)
+	}
+	return nil
+}
+
+func validateIndicatorAlert(alert *Alert) error {
+	if alert.Indicator == nil {
+		return errors.New("indicator alerts need an indicator")
+	}
+	alert.Direction = strings.ToLower(alert.Direction)
+	if alert.Direction != DIRECTION_ABOVE && alert.Direction != DIRECTION_BELOW {
+		return fmt.Errorf("direction must be %q or %q", DIRECTION_ABOVE, DIRECTION_BELOW)
+	}
+
+	spec := alert.Indicator
+	if spec.Interval == "" {
+		spec.Interval = DEFAULT_INDICATOR_INTERVAL
+	}
+	interval, err := parseTimeDelta(spec.Interval)
+	if err != nil {
+		return err
+	}
+	if interval < MIN_INDICATOR_INTERVAL {
+		return fmt.Errorf("indicator interval must be at least %s", MIN_INDICATOR_INTERVAL)
+	}
+
+	switch spec.Type {
+	case INDICATOR_SMA_CROSS, INDICATOR_EMA_CROSS:
+		if err := validatePeriod("fast", spec.Fast); err != nil {
+			return err
+		}
+		if err := validatePeriod("slow", spec.Slow); err != nil {
+			return err
+		}
+		if spec.Fast >= spec.Slow {
+			return errors.New("fast period must be shorter than slow period")
+		}
+	case INDICATOR_RSI:
+		if spec.Period == 0 {
+			spec.Period = 14
+		}
+		if spec.Level == 0 {
+			spec.Level = 70
+			if alert.Direction == DIRECTION_BELOW {
+				spec.Level = 30
+			}
+		}
+		if err := validatePeriod("period", spec.Period); err != nil {
+			return err
+		}
+		if spec.Level <= 0 || spec.Level >= 100 {
+			return errors.New("RSI level must be between 0 and 100")
+		}
+	case INDICATOR_BOLLINGER:
+		if spec.Period == 0 {
+			spec.Period = 20
+		}
+		if spec.Deviations == 0 {
+			spec.Deviations = 2
+		}
+		if err := validatePeriod("period", spec.Period); err != nil {
+			return err
+		}
+		if spec.Deviations < 0 {
+			return errors.New("deviations must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown indicator type %q: expected %s, %s, %s or %s", spec.Type,
+			INDICATOR_SMA_CROSS, INDICATOR_EMA_CROSS, INDICATOR_RSI, INDICATOR_BOLLINGER)
+	}
+	if time.Duration(spec.candles())*interval > MAX_TIME_DELTA {
+		return fmt.Errorf("indicator needs more than %s of history", MAX_TIME_DELTA)
+	}
+	return nil
+}
+
+func evaluateIndicatorAlert(alert Alert, e evaluation) (reading, error) {
+	coinMapKey, coinInfo, err := lookupCoin(alert, e)
+	if err != nil {
+		return reading{}, err
+	}
+	spec := *alert.Indicator
+	interval, err := parseTimeDelta(spec.Interval)
+	if err != nil {
+		return reading{}, err
+	}
+	closes, err := closingPrices(coinMapKey, interval, spec.candles(), e.Now)
+	if err != nil {
+		return reading{}, err
+	}
+
+	var value, level float64
+	var detail string
+	switch spec.Type {
+	case INDICATOR_SMA_CROSS, INDICATOR_EMA_CROSS:
+		average, name := indicators.SMA, "SMA"
+		if spec.Type == INDICATOR_EMA_CROSS {
+			average, name = indicators.EMA, "EMA"
+		}
+		fast, err := average(closes, spec.Fast)
+		if err != nil {
+			return reading{}, historyError(coinMapKey, err)
+		}
+		slow, err := average(closes, spec.Slow)
+		if err != nil {
+			return reading{}, historyError(coinMapKey, err)
+		}
+		value, level = fast[len(fast)-1], slow[len(slow)-1]
+		detail = fmt.Sprintf("fast %s(%d) = %s; slow %s(%d) = %s",
+			name, spec.Fast, formatFloat(value), name, spec.Slow, formatFloat(level))
+	case INDICATOR_RSI:
+		if value, err = indicators.RSI(closes, spec.Period); err != nil {
+			return reading{}, historyError(coinMapKey, err)
+		}
+		level = spec.Level
+		detail = fmt.Sprintf("RSI(%d) = %.2f; level = %s", spec.Period, value, formatFloat(level))
+	case INDICATOR_BOLLINGER:
+		band, err := indicators.Bollinger(closes, spec.Period, spec.Deviations)
+		if err != nil {
+			return reading{}, historyError(coinMapKey, err)
+		}
+		if value, err = strconv.ParseFloat(coinInfo.PriceUSD, 64); err != nil {
+			return reading{}, err
+		}
+		level = band.Lower
+		if alert.Direction == DIRECTION_ABOVE {
+			level = band.Upper
+		}
+		detail = fmt.Sprintf("price = %s; upper = %s; middle = %s; lower = %s", formatFloat(value),
+			formatFloat(band.Upper), formatFloat(band.Middle), formatFloat(band.Lower))
+	default:
+		return reading{}, fmt.Errorf("unknown indicator type %q", spec.Type)
+	}
+
+	r := levelReading(value, level, alert.Direction, alert.Hysteresis)
+	if r.Violation {
+		log.Debugf("Violation %s: %s %s (%s)", alert.CoinSymbol, spec.String(), alert.Direction, detail)
+	}
+
+	r.Notification = coinNotification(coinInfo)
+	r.Notification.Metric = spec.String()
+	r.Notification.Direction = alert.Direction
+	r.Notification.CurrentValue = value
+	r.Notification.ThresholdValue = level
+	r.Notification.Detail = detail
+	return r, nil
+}
+
+func historyError(coinKey string, err error) error {
+	if err == indicators.ErrNotEnoughData {
+		return fmt.Errorf("not enough price history for %s", coinKey)
+	}
+	return err
+}
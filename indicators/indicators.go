@@ -0,0 +1,160 @@
+// Package indicators implements the technical indicators used by indicator
+// alerts. All functions take closing prices ordered oldest first.
+package indicators
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	ErrNotEnoughData = errors.New("indicators: not enough data")
+	ErrInvalidPeriod = errors.New("indicators: period must be positive")
+)
+
+// Band is a Bollinger band at a single point.
+type Band struct {
+	Middle float64
+	Upper  float64
+	Lower  float64
+}
+
+func checkPeriod(values []float64, period int, needed int) error {
+	if period < 1 {
+		return ErrInvalidPeriod
+	}
+	if len(values) < needed {
+		return ErrNotEnoughData
+	}
+	return nil
+}
+
+// SMA returns the simple moving average series. The first value is the
+// average of values[0:period], so the result has len(values)-period+1 points.
+func SMA(values []float64, period int) ([]float64, error) {
+	if err := checkPeriod(values, period, period); err != nil {
+		return nil, err
+	}
+	series := make([]float64, 0, len(values)-period+1)
+	var sum float64
+	for i, v := range values {
+		sum += v
+		if i >= period {
+			sum -= values[i-period]
+		}
+		if i >= period-1 {
+			series = append(series, sum/float64(period))
+		}
+	}
+	return series, nil
+}
+
+// EMA returns the exponential moving average series, seeded with the SMA of
+// the first period values, so it has the same length as SMA.
+func EMA(values []float64, period int) ([]float64, error) {
+	if err := checkPeriod(values, period, period); err != nil {
+		return nil, err
+	}
+	k := 2 / float64(period+1)
+	var seed float64
+	for _, v := range values[:period] {
+		seed += v
+	}
+	ema := seed / float64(period)
+	series := []float64{ema}
+	for _, v := range values[period:] {
+		ema = v*k + ema*(1-k)
+		series = append(series, ema)
+	}
+	return series, nil
+}
+
+// RSI returns the latest relative strength index using Wilder's smoothing.
+// It needs at least period+1 values.
+func RSI(values []float64, period int) (float64, error) {
+	if err := checkPeriod(values, period, period+1); err != nil {
+		return 0, err
+	}
+	var gain, loss float64
+	for i := 1; i <= period; i++ {
+		gain, loss = addMove(gain, loss, values[i]-values[i-1])
+	}
+	gain /= float64(period)
+	loss /= float64(period)
+
+	for i := period + 1; i < len(values); i++ {
+		g, l := addMove(0, 0, values[i]-values[i-1])
+		gain = (gain*float64(period-1) + g) / float64(period)
+		loss = (loss*float64(period-1) + l) / float64(period)
+	}
+
+	if loss == 0 {
+		if gain == 0 {
+			return 50, nil
+		}
+		return 100, nil
+	}
+	return 100 - 100/(1+gain/loss), nil
+}
+
+func addMove(gain float64, loss float64, move float64) (float64, float64) {
+	if move > 0 {
+		return gain + move, loss
+	}
+	return gain, loss - move
+}
+
+// Bollinger returns the band over the last period values, k population
+// standard deviations either side of their simple average.
+func Bollinger(values []float64, period int, k float64) (Band, error) {
+	if err := checkPeriod(values, period, period); err != nil {
+		return Band{}, err
+	}
+	window := values[len(values)-period:]
+	var mean float64
+	for _, v := range window {
+		mean += v
+	}
+	mean /= float64(period)
+
+	var variance float64
+	for _, v := range window {
+		variance += (v - mean) * (v - mean)
+	}
+	deviation := math.Sqrt(variance / float64(period))
+	return Band{Middle: mean, Upper: mean + k*deviation, Lower: mean - k*deviation}, nil
+}
+
+// Returns returns the percent change between consecutive values, so it has
+// len(values)-1 points.
+func Returns(values []float64) ([]float64, error) {
+	if len(values) < 2 {
+		return nil, ErrNotEnoughData
+	}
+	series := make([]float64, 0, len(values)-1)
+	for i := 1; i < len(values); i++ {
+		if values[i-1] == 0 {
+			return nil, errors.New("indicators: return from a zero value")
+		}
+		series = append(series, (values[i]-values[i-1])/values[i-1]*100)
+	}
+	return series, nil
+}
+
+// MeanStdDev returns the mean and sample standard deviation of values.
+func MeanStdDev(values []float64) (float64, float64, error) {
+	if len(values) < 2 {
+		return 0, 0, ErrNotEnoughData
+	}
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)-1)), nil
+}
@@ -0,0 +1,152 @@
+package indicators
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Closing prices from Wilder's RSI example as published by StockCharts. The
+// expected values below are unrounded; StockCharts rounds the intermediate
+// averages, which shifts its figures by a few hundredths.
+var wilderCloses = []float64{
+	44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08,
+	45.89, 46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64,
+}
+
+func TestSMA(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		period   int
+		expected []float64
+		err      error
+	}{
+		{"period 1", []float64{1, 2, 3}, 1, []float64{1, 2, 3}, nil},
+		{"period 3", []float64{1, 2, 3, 4, 5}, 3, []float64{2, 3, 4}, nil},
+		{"whole series", []float64{2, 4, 6, 8}, 4, []float64{5}, nil},
+		{"too short", []float64{1, 2}, 3, nil, ErrNotEnoughData},
+		{"bad period", []float64{1, 2}, 0, nil, ErrInvalidPeriod},
+	}
+	for _, tt := range tests {
+		series, err := SMA(tt.values, tt.period)
+		assert.Equal(t, tt.err, err, tt.name)
+		assert.Equal(t, tt.expected, series, tt.name)
+	}
+}
+
+func TestEMA(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		period   int
+		expected []float64
+	}{
+		// k = 0.5: seed 2, then 4*0.5+2*0.5 = 3, then 8*0.5+3*0.5 = 5.5.
+		{"period 3", []float64{1, 2, 3, 4, 8}, 3, []float64{2, 3, 5.5}},
+		{"flat", []float64{5, 5, 5, 5}, 2, []float64{5, 5, 5}},
+	}
+	for _, tt := range tests {
+		series, err := EMA(tt.values, tt.period)
+		if assert.NoError(t, err, tt.name) && assert.Len(t, series, len(tt.expected), tt.name) {
+			for i := range tt.expected {
+				assert.InDelta(t, tt.expected[i], series[i], 1e-9, tt.name)
+			}
+		}
+	}
+
+	_, err := EMA([]float64{1}, 2)
+	assert.Equal(t, ErrNotEnoughData, err)
+}
+
+func TestRSI(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		period   int
+		expected float64
+	}{
+		{"wilder first value", wilderCloses[:15], 14, 70.46},
+		{"wilder smoothed", wilderCloses[:16], 14, 66.25},
+		{"wilder later", wilderCloses, 14, 57.92},
+		{"only gains", []float64{1, 2, 3, 4}, 3, 100},
+		{"only losses", []float64{4, 3, 2, 1}, 3, 0},
+		{"flat", []float64{1, 1, 1, 1}, 3, 50},
+	}
+	for _, tt := range tests {
+		rsi, err := RSI(tt.values, tt.period)
+		if assert.NoError(t, err, tt.name) {
+			assert.InDelta(t, tt.expected, rsi, 0.01, tt.name)
+		}
+	}
+
+	_, err := RSI(wilderCloses[:14], 14)
+	assert.Equal(t, ErrNotEnoughData, err)
+}
+
+func TestBollinger(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		period   int
+		k        float64
+		expected Band
+	}{
+		{"flat", []float64{3, 3, 3}, 3, 2, Band{3, 3, 3}},
+		{"last period only", []float64{100, 1, 2, 3, 4, 5}, 5, 2, Band{3, 5.8284, 0.1716}},
+		{"one deviation", []float64{2, 4, 4, 4, 5, 5, 7, 9}, 8, 1, Band{5, 7, 3}},
+	}
+	for _, tt := range tests {
+		band, err := Bollinger(tt.values, tt.period, tt.k)
+		if assert.NoError(t, err, tt.name) {
+			assert.InDelta(t, tt.expected.Middle, band.Middle, 1e-4, tt.name)
+			assert.InDelta(t, tt.expected.Upper, band.Upper, 1e-4, tt.name)
+			assert.InDelta(t, tt.expected.Lower, band.Lower, 1e-4, tt.name)
+		}
+	}
+}
+
+func TestReturns(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		expected []float64
+	}{
+		{"up and down", []float64{100, 110, 99}, []float64{10, -10}},
+		{"flat", []float64{5, 5}, []float64{0}},
+	}
+	for _, tt := range tests {
+		series, err := Returns(tt.values)
+		if assert.NoError(t, err, tt.name) {
+			assert.InDeltaSlice(t, tt.expected, series, 1e-9, tt.name)
+		}
+	}
+
+	_, err := Returns([]float64{1})
+	assert.Equal(t, ErrNotEnoughData, err)
+	_, err = Returns([]float64{0, 1})
+	assert.Error(t, err)
+}
+
+func TestMeanStdDev(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		mean   float64
+		stdDev float64
+	}{
+		{"flat", []float64{3, 3, 3}, 3, 0},
+		{"sample deviation", []float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, 2.1381},
+		{"symmetric", []float64{-1, 1}, 0, 1.4142},
+	}
+	for _, tt := range tests {
+		mean, stdDev, err := MeanStdDev(tt.values)
+		if assert.NoError(t, err, tt.name) {
+			assert.InDelta(t, tt.mean, mean, 1e-4, tt.name)
+			assert.InDelta(t, tt.stdDev, stdDev, 1e-4, tt.name)
+		}
+	}
+
+	_, _, err := MeanStdDev([]float64{1})
+	assert.Equal(t, ErrNotEnoughData, err)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ALERT_KIND_LISTING fires when the ticker feed lists, delists or promotes a
+// coin into the top N by rank. Direction picks the event; CoinSymbol
+// optionally restricts the alert to a single coin.
+const ALERT_KIND_LISTING = "listing"
+
+// Listing events.
+const (
+	LISTING_LISTED      = "listed"
+	LISTING_DELISTED    = "delisted"
+	LISTING_ENTERED_TOP = "entered_top"
+)
+
+const LISTING_TOP_N_ENV = "LISTING_TOP_N"
+
+// A coin must be missing from the feed this long before it counts as
+// delisted, so one bad provider response does not delist half the market.
+const DELIST_GRACE = 6 * time.Hour
+
+const DEFAULT_LISTING_TOP_N = 100
+
+// Rank a coin must reach for an "entered_top" event.
+var listingTopN = DEFAULT_LISTING_TOP_N
+
+func init() {
+	registerAlertKind(ALERT_KIND_LISTING, alertKind{validateListingAlert, evaluateListingAlert})
+}
+
+func configureListings() {
+	if v := os.Getenv(LISTING_TOP_N_ENV); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Errorf("invalid %s: %s", LISTING_TOP_N_ENV, v)
+		} else {
+			listingTopN = n
+		}
+	}
+	log.Debugf("listing events for coins entering the top %d", listingTopN)
+}
+
+func listingEvent(event string, key string, coinInfo CoinInfo, rank int, now time.Time) ListingEvent {
+	return ListingEvent{CoinKey: key, CoinSymbol: coinInfo.Symbol, CoinName: coinInfo.Name,
+		Event: event, Rank: rank, OccurredAt: now}
+}
+
+// diffListings compares this tick's coins against the known coin set. It
+// returns the events, updating known in place; coins new to the set are
+// returned in added. The first tick only seeds the set.
+func diffListings(known map[string]*ListedCoin, prices map[string]CoinInfo, now time.Time) ([]ListingEvent, []*ListedCoin) {
+	seeding := len(known) == 0
+	var events []ListingEvent
+	var added []*ListedCoin
+
+	for key, coinInfo := range prices {
+		rank, _ := strconv.Atoi(coinInfo.Rank)
+		coin, ok := known[key]
+		if !ok {
+			coin = &ListedCoin{CoinKey: key, FirstSeenAt: now}
+			added = append(added, coin)
+			if !seeding {
+				events = append(events, listingEvent(LISTING_LISTED, key, coinInfo, rank, now))
+			}
+		} else if coin.DelistedAt != nil {
+			coin.DelistedAt = nil
+			events = append(events, listingEvent(LISTING_LISTED, key, coinInfo, rank, now))
+		}
+		if !seeding && rank >= 1 && rank <= listingTopN && (coin.Rank == 0 || coin.Rank > listingTopN) {
+			events = append(events, listingEvent(LISTING_ENTERED_TOP, key, coinInfo, rank, now))
+		}
+		coin.CoinSymbol, coin.CoinName, coin.Rank, coin.LastSeenAt = coinInfo.Symbol, coinInfo.Name, rank, now
+	}
+
+	for key, coin := range known {
+		if _, ok := prices[key]; ok || coin.DelistedAt != nil || now.Sub(coin.LastSeenAt) < DELIST_GRACE {
+			continue
+		}
+		delistedAt := now
+		coin.DelistedAt = &delistedAt
+		events = append(events, listingEvent(LISTING_DELISTED, key,
+			CoinInfo{Symbol: coin.CoinSymbol, Name: coin.CoinName}, coin.Rank, now))
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].CoinKey < events[j].CoinKey })
+	return events, added
+}
+
+// detectListings updates the known coin set from this tick's prices and
+// stores and returns the listing events. Alerts on delisted coins are
+// marked, and unmarked again if the coin returns.
+func detectListings(prices map[string]CoinInfo, now time.Time) ([]ListingEvent, error) {
+	var coins []ListedCoin
+	if err := db.Find(&coins).Error; err != nil {
+		return nil, err
+	}
+	known := make(map[string]*ListedCoin)
+	for i := range coins {
+		known[coins[i].CoinKey] = &coins[i]
+	}
+
+	events, added := diffListings(known, prices, now)
+
+	tx := db.Begin()
+	for _, coin := range added {
+		tx.Create(coin)
+	}
+	for _, coin := range known {
+		if !coin.LastSeenAt.Equal(now) && (coin.DelistedAt == nil || !coin.DelistedAt.Equal(now)) {
+			continue
+		}
+		tx.Model(coin).UpdateColumns(map[string]interface{}{
+			"coin_symbol": coin.CoinSymbol, "coin_name": coin.CoinName, "rank": coin.Rank,
+			"last_seen_at": coin.LastSeenAt, "delisted_at": coin.DelistedAt,
+		})
+	}
+	for i := range events {
+		tx.Create(&events[i])
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]*time.Time)
+	for _, event := range events {
+		switch event.Event {
+		case LISTING_DELISTED:
+			changed[event.CoinKey] = known[event.CoinKey].DelistedAt
+		case LISTING_LISTED:
+			changed[event.CoinKey] = nil
+		}
+	}
+	if len(changed) > 0 {
+		if err := markDelistedAlerts(changed); err != nil {
+			return events, err
+		}
+	}
+	if len(events) > 0 {
+		log.Debugf("detected %d listing events", len(events))
+	}
+	return events, nil
+}
+
+// alertCoinKeys returns the keys of the coins an alert is evaluated on.
+func alertCoinKeys(alert Alert) []string {
+	var keys []string
+	if alert.CoinSymbol != "" {
+		keys = append(keys, createCoinKey(alert.CoinSymbol, alert.CoinName))
+	}
+	if alert.QuoteSymbol != "" {
+		keys = append(keys, createCoinKey(alert.QuoteSymbol, alert.QuoteName))
+	}
+	return keys
+}
+
+// markDelistedAlerts sets or clears delisted_at on alerts whose coins were
+// delisted (a non-nil time) or relisted (nil). Listing alerts are left alone.
+func markDelistedAlerts(changed map[string]*time.Time) error {
+	var alerts []Alert
+	if err := db.Where("kind IS NULL OR kind <> ?", ALERT_KIND_LISTING).Find(&alerts).Error; err != nil {
+		return err
+	}
+	for _, alert := range alerts {
+		for _, key := range alertCoinKeys(alert) {
+			delistedAt, ok := changed[key]
+			if !ok {
+				continue
+			}
+			log.Debugf("Marking alert ID(%d) delisted_at=%v for %s", alert.ID, delistedAt, key)
+			if err := db.Model(&alert).UpdateColumn("delisted_at", delistedAt).Error; err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func validateListingAlert(alert *Alert) error {
+	alert.Direction = strings.ToLower(alert.Direction)
+	if alert.Direction == "" {
+		alert.Direction = LISTING_LISTED
+	}
+	if alert.Direction != LISTING_LISTED && alert.Direction != LISTING_DELISTED && alert.Direction != LISTING_ENTERED_TOP {
+		return fmt.Errorf("direction must be %q, %q or %q", LISTING_LISTED, LISTING_DELISTED, LISTING_ENTERED_TOP)
+	}
+	if alert.CoinName != "" && alert.CoinSymbol == "" {
+		return errors.New("coin_name needs a coin_symbol")
+	}
+	alert.CoinSymbol = strings.ToUpper(alert.CoinSymbol)
+	return nil
+}
+
+func evaluateListingAlert(alert Alert, e evaluation) (reading, error) {
+	var symbols, names, observed []string
+	for _, event := range e.Listings {
+		if event.Event != alert.Direction || (alert.CoinSymbol != "" && !strings.EqualFold(event.CoinSymbol, alert.CoinSymbol)) ||
+			(alert.CoinName != "" && !strings.EqualFold(event.CoinName, alert.CoinName)) {
+			continue
+		}
+		symbols = append(symbols, event.CoinSymbol)
+		names = append(names, event.CoinName)
+		observed = append(observed, fmt.Sprintf("%s (%s, rank %d)", event.CoinSymbol, event.CoinName, event.Rank))
+	}
+
+	// Each tick's events are new, so the alert stays armed and fires on
+	// every tick with a matching event.
+	matched := len(observed) > 0
+	r := reading{Value: float64(len(observed)), Violation: matched, Rearmed: true}
+	if matched {
+		log.Debugf("Listing alert ID(%d): %s %s", alert.ID, alert.Direction, strings.Join(symbols, ", "))
+	}
+	r.Notification = Notification{
+		CoinSymbol: strings.Join(symbols, ", "), CoinName: strings.Join(names, ", "),
+		LastUpdated: e.Now.Unix(), Metric: "listing", Direction: alert.Direction,
+		CurrentValue: float64(len(observed)), Detail: strings.Join(observed, "; "),
+	}
+	if alert.Direction == LISTING_ENTERED_TOP {
+		r.Notification.ThresholdValue = float64(listingTopN)
+	}
+	return r, nil
+}
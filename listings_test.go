@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffListings(t *testing.T) {
+	now := time.Now()
+	btc := CoinInfo{Symbol: "BTC", Name: "Bitcoin", Rank: "1"}
+	eth := CoinInfo{Symbol: "ETH", Name: "Ethereum", Rank: "2"}
+
+	known := make(map[string]*ListedCoin)
+	events, added := diffListings(known, map[string]CoinInfo{"BTC_BITCOIN": btc}, now)
+	assert.Empty(t, events, "the first tick only seeds the known set")
+	assert.Len(t, added, 1)
+
+	known["BTC_BITCOIN"] = added[0]
+	known["OLD_OLDCOIN"] = &ListedCoin{CoinKey: "OLD_OLDCOIN", CoinSymbol: "OLD", Rank: 300, LastSeenAt: now.Add(-DELIST_GRACE)}
+	known["FLAKY_FLAKYCOIN"] = &ListedCoin{CoinKey: "FLAKY_FLAKYCOIN", Rank: 400, LastSeenAt: now.Add(-time.Hour)}
+	later := now.Add(30 * time.Minute)
+	events, added = diffListings(known, map[string]CoinInfo{"BTC_BITCOIN": btc, "ETH_ETHEREUM": eth}, later)
+
+	assert.Len(t, added, 1)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, LISTING_LISTED, events[0].Event)
+		assert.Equal(t, LISTING_ENTERED_TOP, events[1].Event)
+		assert.Equal(t, "ETH_ETHEREUM", events[1].CoinKey)
+		assert.Equal(t, LISTING_DELISTED, events[2].Event)
+		assert.Equal(t, "OLD_OLDCOIN", events[2].CoinKey)
+	}
+	assert.Nil(t, known["FLAKY_FLAKYCOIN"].DelistedAt, "coins missing for less than DELIST_GRACE stay listed")
+}
+
+func TestEvaluateListingAlert(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_LISTING}
+	assert.NoError(t, validateAlert(&alert))
+	assert.Equal(t, LISTING_LISTED, alert.Direction)
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_LISTING, Direction: "renamed"}))
+
+	e := testEvaluation()
+	e.Listings = []ListingEvent{
+		{CoinSymbol: "NEW", CoinName: "Newcoin", Event: LISTING_LISTED, Rank: 812},
+		{CoinSymbol: "OLD", CoinName: "Oldcoin", Event: LISTING_DELISTED},
+	}
+	r, err := evaluateAlert(alert, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, "NEW", r.Notification.CoinSymbol)
+		assert.Contains(t, notificationDetail(r.Notification), "<b>Coins</b>: NEW (Newcoin, rank 812)")
+	}
+
+	// A triggered listing alert fires again on the next tick with new events.
+	alert.Triggered = true
+	assert.True(t, advanceAlertState(&alert, r, e.Now))
+}
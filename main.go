@@ -5,7 +5,6 @@ import (
 	"github.com/jasonlvhit/gocron"
 	"net/http"
 	"github.com/labstack/echo"
-	sms "stathat.com/c/amzses"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"github.com/op/go-logging"
@@ -14,8 +13,8 @@ import (
 	"strconv"
 	"encoding/json"
 	"strings"
-	"github.com/levigross/grequests"
 	"os"
+	"errors"
 )
 
 type UserEmail struct {
@@ -28,9 +27,32 @@ type Alert struct {
 	Email          string `json:"email"`
 	CoinName       string `json:"coin_name"`
 	CoinSymbol     string `json:"coin_symbol"`
+	CoinID         string `json:"coin_id"` // catalog ID, stable across renames.
 	ThresholdDelta float64 `json:"threshold_delta"`
 	TimeDelta      string `json:"time_delta"`
 	Active         bool `json:"active"`
+	Kind           string `json:"kind"` // one of the kinds registered in alertKinds; "change" when empty.
+	Direction      string `json:"direction"` // "above" or "below"; "enters" or "exits" for rank alerts.
+	PriceLevel     float64 `json:"price_level"` // also the peg for de-peg alerts.
+	PriceCurrency  string `json:"price_currency"` // "USD" (default), "BTC" or a fiat code such as "EUR"; also used by market cap alerts.
+	Hysteresis     float64 `json:"hysteresis"` // distance the value must retreat past the threshold to re-arm, in threshold units.
+	Triggered      bool `json:"triggered"` // true while disarmed after firing.
+	LastValue      float64 `json:"last_value"`
+	LastEvaluatedAt *time.Time `json:"last_evaluated_at"`
+	CooldownMinutes int `json:"cooldown_minutes"` // minimum time between notifications; 0 uses DEFAULT_COOLDOWN.
+	Threshold      float64 `json:"threshold"` // level for volume, market cap and rank alerts; sigma for volatility alerts; basis points for de-peg alerts.
+	Rule           *Rule `json:"rule,omitempty" gorm:"type:text"` // expression for composite alerts.
+	QuoteSymbol    string `json:"quote_symbol"` // denominator coin for pair alerts.
+	QuoteName      string `json:"quote_name"`
+	QuoteID        string `json:"quote_id"`
+	Indicator      *IndicatorSpec `json:"indicator,omitempty" gorm:"type:text"` // settings for indicator alerts.
+	Lookback       string `json:"lookback"` // history used to measure volatility, e.g. "30d".
+	SourceA        string `json:"source_a"` // price providers compared by spread alerts.
+	SourceB        string `json:"source_b"`
+	Metric         string `json:"metric"` // global metric for market alerts, e.g. "btc_dominance".
+	Ticks          int `json:"ticks"` // consecutive scheduler ticks a de-peg must last before firing.
+	DelistedAt     *time.Time `json:"delisted_at"` // set while the alert's coin is missing from the feed.
+	Channels       string `json:"channels"` // comma-separated notifier names; DEFAULT_CHANNEL when empty.
 }
 
 type Notification struct {
@@ -43,6 +65,199 @@ type Notification struct {
 	ThresholdDelta float64
 	TimeDelta      string
 	LastUpdated    int64
+	Sources        string // comma-separated price providers that contributed to the quote.
+	Kind           string
+	Metric         string // e.g. "price_usd", for alerts on a level rather than a percent change.
+	Direction      string
+	CurrentValue   float64
+	ThresholdValue float64
+	Currency       string
+	CooldownMinutes int
+	Baseline       float64 // reference value the observation is compared to, e.g. trailing average volume.
+	Detail         string // observed values behind composite and indicator alerts.
+	FXRate         float64 // units of Currency per USD used to convert the values, 0 if unconverted.
+	FXSource       string
+	FXRatesAt      *time.Time
+	Portfolio      *PortfolioSummary `gorm:"type:text"` // the user's holdings as valued when a portfolio alert fired.
+	Channels       string // notifiers the notification was routed to.
+	DeliveredAt    *time.Time // first successful delivery over any channel; nil while pending or dead-lettered.
+}
+
+// OutboxMessage is a notification waiting to go out over one channel. It is
+// written in the same transaction as the notification, so nothing is lost
+// when sending fails or the process stops before the dispatcher runs.
+type OutboxMessage struct {
+	gorm.Model
+	NotificationID uint
+	AlertID        uint
+	Email          string
+	AlertName      string
+	Channel        string
+	Status         string // OUTBOX_PENDING, OUTBOX_DELIVERED or OUTBOX_DEAD.
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastError      string
+	DeliveredAt    *time.Time
+}
+
+// DeliveryReceipt records that a notification reached one target of a
+// channel, such as one of a user's webhooks, so a retry of the outbox entry
+// only goes to the targets that missed it.
+type DeliveryReceipt struct {
+	gorm.Model
+	NotificationID uint
+	Channel        string
+	TargetID       uint // the Webhook or ChatIntegration ID.
+}
+
+// User holds per-user account settings, keyed by email.
+type User struct {
+	gorm.Model
+	Email string `json:"email"`
+	Plan  string `json:"plan"`
+	Currency string `json:"currency"` // default quote currency for new price alerts, e.g. "EUR".
+}
+
+// PriceSnapshot is one coin's quote as seen on a single scheduler tick.
+type PriceSnapshot struct {
+	gorm.Model
+	CoinKey      string
+	CoinSymbol   string
+	CoinName     string
+	PriceUSD     float64
+	PriceBTC     float64
+	Volume24     float64
+	MarketCapUSD float64
+	Rank         int
+	TakenAt      time.Time
+}
+
+// MarketSnapshot holds the global market metrics computed on one tick.
+// Shares are percentages of the total market cap.
+type MarketSnapshot struct {
+	gorm.Model
+	TotalMarketCap float64
+	BTCDominance   float64
+	Top10Share     float64
+	CoinsUp        int
+	CoinsDown      int
+	TakenAt        time.Time
+}
+
+// Webhook is an endpoint a user registered to receive notifications as
+// signed JSON. Secret is only returned when the webhook is registered.
+type Webhook struct {
+	gorm.Model
+	Email  string `json:"email"`
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+	Active bool `json:"active"`
+}
+
+// ChatIntegration sends a user's notifications to their own Slack or
+// Discord webhook, or to a Telegram chat the operator's bot is in.
+type ChatIntegration struct {
+	gorm.Model
+	Email  string `json:"email"`
+	Kind   string `json:"kind"` // "slack", "discord" or "telegram".
+	URL    string `json:"url"` // incoming webhook URL for slack and discord.
+	ChatID string `json:"chat_id"` // telegram chat ID.
+}
+
+// PhoneNumber is a user's number for SMS notifications. Only verified
+// numbers are sent notifications.
+type PhoneNumber struct {
+	gorm.Model
+	Email        string `json:"email"`
+	Number       string `json:"number"` // E.164, e.g. "+14155550123".
+	CodeHash     string `json:"-"` // SHA-256 of the pending verification code.
+	CodeSentAt   *time.Time `json:"-"`
+	CodeAttempts int `json:"-"`
+	VerifiedAt   *time.Time `json:"verified_at"`
+}
+
+// SMSMessage records an SMS sent to a user, counted against their plan's
+// daily quota.
+type SMSMessage struct {
+	gorm.Model
+	Email   string
+	Number  string
+	Purpose string // "notification" or "verification".
+	Sent    bool
+	Error   string
+}
+
+// WebhookDelivery records one attempt to post a payload to a webhook.
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID  uint `json:"webhook_id"`
+	PayloadID  string `json:"payload_id"` // the same on every attempt at one payload.
+	Attempt    int `json:"attempt"`
+	StatusCode int `json:"status_code"` // 0 if no response was received.
+	Delivered  bool `json:"delivered"`
+	Error      string `json:"error"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// Holding is a position in a user's portfolio. CostBasis is the total paid
+// for the position, in USD.
+type Holding struct {
+	gorm.Model
+	Email            string `json:"email"`
+	CoinID           string `json:"coin_id"`
+	CoinSymbol       string `json:"coin_symbol"`
+	CoinName         string `json:"coin_name"`
+	Quantity         float64 `json:"quantity"`
+	CostBasis        float64 `json:"cost_basis"`
+	TargetAllocation float64 `json:"target_allocation"` // percent of portfolio value, 0 if untargeted.
+}
+
+// Coin is a coin catalog entry, keyed by the provider's coin ID.
+type Coin struct {
+	gorm.Model
+	CoinID     string `json:"coin_id"`
+	Symbol     string `json:"symbol"`
+	Name       string `json:"name"`
+	Rank       int `json:"rank"`
+	Aliases    string `json:"aliases"` // comma-separated former symbols and names.
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// ListedCoin is a coin in the known coin set, updated from the ticker feed
+// on every tick.
+type ListedCoin struct {
+	gorm.Model
+	CoinKey     string `json:"coin_key"`
+	CoinSymbol  string `json:"coin_symbol"`
+	CoinName    string `json:"coin_name"`
+	Rank        int `json:"rank"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	DelistedAt  *time.Time `json:"delisted_at"`
+}
+
+// ListingEvent records a coin appearing in or disappearing from the feed, or
+// entering the top N by rank.
+type ListingEvent struct {
+	gorm.Model
+	CoinKey    string `json:"coin_key"`
+	CoinSymbol string `json:"coin_symbol"`
+	CoinName   string `json:"coin_name"`
+	Event      string `json:"event"` // "listed", "delisted" or "entered_top".
+	Rank       int `json:"rank"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// TaskRun records the outcome of a single runCoinTask pass.
+type TaskRun struct {
+	gorm.Model
+	Status        string
+	Error         string
+	FailedSources string
+	Coins         int
+	StaleQuotes   int
+	Notifications int
+	FinishedAt    time.Time
 }
 
 type CoinInfo struct {
@@ -60,14 +275,24 @@ type CoinInfo struct {
 	Change24h    string `json:"percent_change_24h"`
 	Change7d     string `json:"percent_change_7d"`
 	LastUpdated  string `json:"last_updated"`
+	Sources      []string `json:"-"`
 }
 
 var db *gorm.DB
 var log = logging.MustGetLogger("crypto")
 
-const MIN_HOUR_EMAIL_INTERVAL = 12.0
+
+// TaskRun statuses.
+const (
+	RUN_OK        = "ok"
+	RUN_NO_ALERTS = "no_alerts"
+	RUN_SKIPPED   = "skipped"
+)
 const COIN_API = "https://api.coinmarketcap.com/v1/ticker/";
 
+// Minutes between runCoinTask runs, and so between stored price snapshots.
+const COIN_TASK_INTERVAL = 30
+
 func makeTimestamp() int64 {
 	return time.Now().UnixNano() / int64(time.Millisecond)
 }
@@ -80,11 +305,15 @@ func createCoinKey(coinSymbol string, coinName string) string {
 	return strings.ToUpper(coinSymbol + "_" + coinName)
 }
 
-func insertNotification(n Notification) {
-	log.Debugf("Inserting notification: %s", n)
-	db.Create(&n)
+func recordTaskRun(run *TaskRun) {
+	run.FinishedAt = time.Now()
+	log.Debugf("runCoinTask finished with status %s", run.Status)
+	if err := db.Create(run).Error; err != nil {
+		log.Error(err.Error())
+	}
 }
 
+
 func min(a, b int) int {
 	if a <= b {
 		return a
@@ -92,59 +321,36 @@ func min(a, b int) int {
 	return b
 }
 
-func sendNotificationsToUser(email string, notificationMap map[string]Notification) string {
-	const adminEmail = "cryptoalarms@gmail.com"
-	const emailDisplayName = "CryptoAlarms Notifications"
-
-	var coinsArr []string
-
-	var alertNames []string
-	var ns []Notification
-	for alertName, notification := range notificationMap {
-		coinsArr = append(coinsArr, notification.CoinSymbol)
-		ns = append(ns, notification)
-		alertNames = append(alertNames, alertName)
-	}
-	coinString := strings.Join(coinsArr, ", ")
-	coinString = coinString[0:min(len(coinString), 20)] // cap the string length at 20.
-
-	var subject = fmt.Sprintf("[%s] %s passed change threshold.", emailDisplayName, coinString)
-	var body = createEmailBodyFromNotifications(alertNames, ns)
-
-	//func SendMailHTML(from, to, subject, bodyText, bodyHTML string) (string, error)
-
-	res, err := sms.SendMailHTML(adminEmail, email, subject, "", body)
-	if (err != nil) {
-		log.Error(email, err.Error())
-	}
-	log.Debugf("Sending email for %s, result: %s", email, res)
-
-	return subject
-}
-
 func isViolation(change float64, threshold float64) bool {
 	return (threshold < 0 && change < threshold) || (threshold > 0 && change > threshold)
 }
 
-func noRecentViolations(email string, coinSymbol string, coinName string) bool {
-	// Retrieve the latest alert for the user for this particular Coin (if present).
+func noRecentViolations(alert Alert) bool {
+	// Retrieve the latest notification for this particular alert (if present).
 	var notification Notification
 	var err error
-	err = db.Table("notifications").Where("email = ? AND coin_symbol = ? AND coin_name = ?",
-		email, coinSymbol, coinName).Order("created_at desc").First(&notification).Error
+	if (outboxPending(alert.ID)) {
+		log.Debugf("Alert ID(%d) still has a notification waiting to be delivered", alert.ID)
+		return false
+	}
+	// Only delivered notifications count, so a failed send does not start the cooldown.
+	err = db.Table("notifications").Where("alert_id = ? AND delivered_at IS NOT NULL", alert.ID).
+		Order("created_at desc").First(&notification).Error
 
-	// Return false if the last notification was Created within the minimum interval.
+	// Return false if the last notification was Created within the alert's cooldown.
 	if (err != nil) {
-		log.Debugf("First notification for (%s, %s)", coinSymbol, email)
-		log.Debugf("notification: %s", notification)
-		log.Error(err)
+		log.Debugf("First notification for alert ID(%d) (%s)", alert.ID, alert.Email)
+		if (!gorm.IsRecordNotFoundError(err)) {
+			log.Error(err)
+		}
 		return true
 	}
 
+	cooldown := alertCooldown(alert)
 	diff := time.Now().Sub(notification.CreatedAt)
-	noRecentViolation := diff.Hours() >= MIN_HOUR_EMAIL_INTERVAL
-	log.Debugf("Violation for coin %s, received notification within %d hours ago (hours ago: %d) - noRecentViolation(%s)",
-		coinSymbol, MIN_HOUR_EMAIL_INTERVAL, diff.Hours(), noRecentViolation)
+	noRecentViolation := diff >= cooldown
+	log.Debugf("Violation for alert ID(%d), last notification %s ago (cooldown %s) - noRecentViolation(%t)",
+		alert.ID, diff, cooldown, noRecentViolation)
 
 	return noRecentViolation
 }
@@ -157,29 +363,34 @@ func printNotificationMap(x map[string]map[string]Notification) {
 	fmt.Print(string(b))
 }
 
-func getCurrencyPrices() map[string]CoinInfo {
-	resp, err := grequests.Get(COIN_API, nil)
-	if (err != nil) {
-		log.Error(err.Error())
-	}
+// getCurrencyPrices queries all configured providers concurrently and
+// consolidates their quotes into one CoinInfo per coin key. Providers that
+// failed are returned alongside, and an error is returned when no usable
+// prices remain so the caller can skip the cycle.
+func getCurrencyPrices() (map[string]CoinInfo, map[string]error, error) {
+	CoinDeltas, _, failures, err := getSourcedCurrencyPrices()
+	return CoinDeltas, failures, err
+}
 
-	var coinInfos []CoinInfo
-	err = json.Unmarshal(resp.Bytes(), &coinInfos)
-	if (err != nil) {
-		fmt.Println("error unmarshalling coin api response", err.Error())
+// getSourcedCurrencyPrices is getCurrencyPrices that also returns each
+// provider's own quotes, keyed by provider name.
+func getSourcedCurrencyPrices() (map[string]CoinInfo, map[string]map[string]CoinInfo, map[string]error, error) {
+	sourcePrices, failures := fetchAllPrices(priceProviders)
+	if (len(sourcePrices) == 0) {
+		return nil, nil, failures, errors.New("no price provider returned coin data")
 	}
-
-	log.Debug("found coininfo for ", len(coinInfos), " coins")
-
-	var CoinDeltas = make(map[string]CoinInfo)
-	for _, coinInfo := range coinInfos {
-		CoinDeltas[createCoinKey(coinInfo.Symbol, coinInfo.Name)] = coinInfo
+	CoinDeltas := aggregatePrices(sourcePrices, aggregation)
+	if (len(CoinDeltas) == 0) {
+		return nil, sourcePrices, failures, errors.New("no coins met the price quorum")
 	}
-	return CoinDeltas
+	return CoinDeltas, sourcePrices, failures, nil
 }
 
 func runCoinTask() {
 	log.Debugf("runCoinTask: %s", time.Now().String())
+	run := TaskRun{Status: RUN_OK}
+	defer recordTaskRun(&run)
+
 	var alerts []Alert
 
 	db.Table("alerts").Where("active = true").Find(&alerts)
@@ -187,69 +398,80 @@ func runCoinTask() {
 	numAlerts := len(alerts)
 	log.Debug("Found active alerts: ", numAlerts)
 
+	CoinDeltas, sourcePrices, failures, err := getSourcedCurrencyPrices()
+	var failed []string
+	for source, failure := range failures {
+		failed = append(failed, source + ": " + failure.Error())
+	}
+	run.FailedSources = strings.Join(failed, "; ")
+	if (err != nil) {
+		log.Error("skipping alert evaluation this cycle: ", err.Error())
+		run.Status = RUN_SKIPPED
+		run.Error = err.Error()
+		return
+	}
+	run.Coins = len(CoinDeltas)
+	now := time.Now()
+
+	// Record the tick even when there is nothing to evaluate, so history stays continuous.
+	if err := insertSnapshots(snapshotsFromPrices(CoinDeltas, now)); err != nil {
+		log.Error("failed to store price snapshots: ", err.Error())
+	}
+
+	market := computeMarketMetrics(CoinDeltas, now)
+	if err := insertMarketSnapshot(market); err != nil {
+		log.Error("failed to store market snapshot: ", err.Error())
+	}
+	if err := updateCoinCatalog(CoinDeltas, now); err != nil {
+		log.Error("failed to update the coin catalog: ", err.Error())
+	}
+	listings, err := detectListings(CoinDeltas, now)
+	if err != nil {
+		log.Error("failed to update the known coin set: ", err.Error())
+	}
+
 	if (numAlerts == 0) {
 		log.Debugf("No active alerts, returning from runCoinTask")
+		run.Status = RUN_NO_ALERTS
 		return
 	}
 
-	CoinDeltas := getCurrencyPrices()
-
 	var notificationMap = make(map[string]map[string]Notification)
 
+	holdings, err := loadHoldings()
+	if (err != nil) {
+		log.Error("failed to load holdings: ", err.Error())
+	}
+	e := evaluation{CoinDeltas: CoinDeltas, CoinKeys: coinKeysByID(CoinDeltas), Now: now, Listings: listings,
+		FX: fetchFXRates(), Holdings: holdings, Market: market, SourcePrices: sourcePrices}
 	for _, alert := range alerts {
-		// element is the element from someSlice for where we are
-
-
-		coinMapKey := createCoinKey(alert.CoinSymbol, alert.CoinName)
-		coinInfo, ok := CoinDeltas[coinMapKey]
-		if !ok {
-			log.Error("Could not find coin with key", coinMapKey, " in api response map")
+		if (alert.DelistedAt != nil) {
+			log.Debugf("Skipping alert ID(%d): coin delisted at %s", alert.ID, alert.DelistedAt)
+			continue
 		}
-
-
-		// Parse the Coin data for the change.
-		var change float64
-		var err error
-
-		switch alert.TimeDelta {
-		case "7d":
-			change, err = strconv.ParseFloat(coinInfo.Change7d, 64)
-		case "1h":
-			change, err = strconv.ParseFloat(coinInfo.Change1h, 64)
-		case "24h":
-			change, err = strconv.ParseFloat(coinInfo.Change24h, 64)
-		default:
-			log.Error("Unexpected alert.TimeDelta for alert ID($1): $2", alert.ID, alert.TimeDelta)
-			change, err = strconv.ParseFloat(coinInfo.Change1h, 64)
+		r, err := evaluateAlert(alert, e)
+		if (err == errStaleQuote) {
+			run.StaleQuotes++
+			continue
 		}
-
 		if (err != nil) {
-			log.Error(err.Error())
+			log.Errorf("Could not evaluate alert ID(%d): %s", alert.ID, err.Error())
+			continue
 		}
 
-		violation := isViolation(change, alert.ThresholdDelta)
-		// log.Debugf("CoinInfo %s: %s", alert.CoinSymbol, coinInfo)
-		if (violation) {
-			log.Debugf("Violation %s: (actual, threshold)=(%f, %f)",
-				alert.CoinSymbol, change, alert.ThresholdDelta)
-		}
-
-		if (violation && noRecentViolations(alert.Email, coinInfo.Symbol, coinInfo.Name)) {
-
-			lastUpdated, err := strconv.ParseInt(coinInfo.LastUpdated, 10, 64)
-
-			if (err != nil) {
-				log.Error(err.Error())
-				lastUpdated = makeTimestamp()
+		fire := advanceAlertState(&alert, r, now)
+		notification := r.Notification
+		notification.CooldownMinutes = int(alertCooldown(alert) / time.Minute)
+		notification.Channels = strings.Join(alertChannels(alert.Channels), ",")
+
+		if (fire && noRecentViolations(alert)) {
+			// Saves the alert state too; on failure the alert stays armed and
+			// fires again on the next tick.
+			if err := insertNotification(&notification, alert); (err != nil) {
+				log.Errorf("Could not queue notification for alert ID(%d): %s", alert.ID, err.Error())
+				continue
 			}
-
-			notification := Notification{
-				AlertId: alert.ID, Email: alert.Email, CoinName: coinInfo.Name, CoinSymbol: coinInfo.Symbol,
-				TimeDelta: alert.TimeDelta, CurrentDelta: change, ThresholdDelta: alert.ThresholdDelta,
-				LastUpdated: lastUpdated,
-			}
-
-			insertNotification(notification)
+			run.Notifications++
 
 			//if _, ok := notificationMap[alert.email]; !ok {
 			//	notificationMap[alert.email] = []
@@ -261,19 +483,17 @@ func runCoinTask() {
 			}
 			// Append notification to alert map.
 			notificationMap[alert.Email][alert.Name] = notification
-		} // else no violation, continue.
+		} else {
+			saveAlertState(alert)
+		}
 	} // end row (alert config) iteration.
 
 	log.Debug("done scanning active alerts from the alert table")
 	log.Debugf("generating the following notifications:")
 	printNotificationMap(notificationMap)
 
-	// Send out the aggregated coin notification emails to user recipients.
-	for email, notificationMap := range notificationMap {
-		fmt.Printf("key[%s] value[%v]\n", email, notificationMap)
-		res := sendNotificationsToUser(email, notificationMap)
-		log.Debug(email, res)
-	}
+	// Send out the queued notifications now rather than on the dispatcher's next tick.
+	dispatchOutbox(time.Now())
 }
 
 func checkTables() {
@@ -343,6 +563,34 @@ func main() {
 	e.POST("/api/alerts/delete", deleteAlert)
 	e.GET("/api/alerts/:email", getAlerts)
 
+	// Routes for account settings, e.g. the default quote currency.
+	e.GET("/api/users/:email/settings", getUserSettings)
+	e.POST("/api/users/settings", updateUserSettings)
+
+	// Routes for manipulating portfolio holdings.
+	e.POST("/api/holdings", addHolding)
+	e.PUT("/api/holdings/:id", updateHolding)
+	e.POST("/api/holdings/delete", deleteHolding)
+	e.GET("/api/holdings/:email", getHoldings)
+
+	// Routes for registering webhooks and reviewing their deliveries.
+	e.POST("/api/webhooks", addWebhook)
+	e.POST("/api/webhooks/delete", deleteWebhook)
+	e.GET("/api/webhooks/:id/deliveries", getWebhookDeliveries)
+
+	// Routes for connecting Slack, Discord and Telegram.
+	e.POST("/api/integrations", addChatIntegration)
+	e.POST("/api/integrations/delete", deleteChatIntegration)
+	e.GET("/api/integrations/:email", getChatIntegrations)
+
+	// Routes for adding and verifying a phone number for SMS.
+	e.POST("/api/phone", addPhoneNumber)
+	e.POST("/api/phone/verify", verifyPhoneNumber)
+	e.GET("/api/phone/:email", getPhoneNumber)
+
+	// Coin catalog search, e.g. /api/coins?q=bit
+	e.GET("/api/coins", getCoins)
+
 	// Routes for manipulating notifications generated by alerts.
 	e.GET("/api/notifications/:email", getNotifications)
 	e.POST("/api/notifications/delete", deleteNotifications)
@@ -357,23 +605,61 @@ func main() {
 		log.Error(err.Error())
 	}
 	checkTables()
-	db.AutoMigrate(&Alert{}, &Notification{})
+	tracksDelivery := db.Dialect().HasColumn("notifications", "delivered_at")
+	db.AutoMigrate(&Alert{}, &Notification{}, &TaskRun{}, &PriceSnapshot{}, &User{}, &ListedCoin{}, &ListingEvent{}, &Coin{}, &Holding{}, &MarketSnapshot{}, &Webhook{}, &WebhookDelivery{}, &ChatIntegration{}, &PhoneNumber{}, &SMSMessage{}, &OutboxMessage{}, &DeliveryReceipt{})
 	log.Debug("tables migrated")
 	// After migration.
 	checkTables()
+	if !tracksDelivery {
+		if err := backfillDeliveredAt(); err != nil {
+			log.Error(err.Error())
+		}
+	}
 
 	db.Model(&Alert{}).AddIndex("alert_idx_email", "email")
 	db.Model(&Notification{}).AddIndex("notfication_idx_email", "email")
+	db.Model(&Notification{}).AddIndex("notification_idx_alert_created", "alert_id", "created_at")
+	db.Model(&User{}).AddUniqueIndex("user_idx_email", "email")
 	db.Model(&Notification{}).AddForeignKey("alert_id", "alerts(ID)", "RESTRICT", "RESTRICT")
+	db.Model(&PriceSnapshot{}).AddIndex("price_snapshot_idx_coin_taken", "coin_key", "taken_at")
+	db.Model(&ListedCoin{}).AddUniqueIndex("listed_coin_idx_key", "coin_key")
+	db.Model(&Coin{}).AddUniqueIndex("coin_idx_coin_id", "coin_id")
+	db.Model(&Holding{}).AddIndex("holding_idx_email", "email")
+	db.Model(&MarketSnapshot{}).AddIndex("market_snapshot_idx_taken", "taken_at")
+	db.Model(&ListingEvent{}).AddIndex("listing_event_idx_occurred", "occurred_at")
+	db.Model(&Webhook{}).AddIndex("webhook_idx_email", "email")
+	db.Model(&WebhookDelivery{}).AddIndex("webhook_delivery_idx_webhook", "webhook_id")
+	db.Model(&WebhookDelivery{}).AddIndex("webhook_delivery_idx_payload", "payload_id")
+	db.Model(&ChatIntegration{}).AddUniqueIndex("chat_integration_idx_email_kind", "email", "kind")
+	db.Model(&PhoneNumber{}).AddUniqueIndex("phone_number_idx_email", "email")
+	db.Model(&SMSMessage{}).AddIndex("sms_message_idx_email_created", "email", "created_at")
+	db.Model(&SMSMessage{}).AddIndex("sms_message_idx_number_created", "number", "created_at")
+	db.Model(&SMSMessage{}).AddIndex("sms_message_idx_created", "created_at")
+	db.Model(&OutboxMessage{}).AddIndex("outbox_message_idx_status_next", "status", "next_attempt_at")
+	db.Model(&OutboxMessage{}).AddIndex("outbox_message_idx_alert_status", "alert_id", "status")
+	db.Model(&DeliveryReceipt{}).AddUniqueIndex("delivery_receipt_idx_target", "channel", "target_id", "notification_id")
+
+	if err := configurePriceProviders(); err != nil {
+		log.Error(err.Error())
+	}
+	configureAggregation()
+	configureFetchPolicy()
+	configureListings()
+	configureFX()
+	if err := configureNotifiers(); err != nil {
+		log.Error(err.Error())
+	}
 
 	// TODO: readd schedule
 	scheduling := true
 	if (scheduling) {
 		var interval uint64
-		interval = 30
+		interval = COIN_TASK_INTERVAL
 		s := gocron.NewScheduler()
 		s.Every(interval).Minutes().Do(runCoinTask)
 		log.Debugf("scheduled alert task for every %d minutes", interval)
+		// Retries, and notifications left queued by a previous process.
+		s.Every(OUTBOX_DISPATCH_INTERVAL).Minutes().Do(runOutboxDispatcher)
 		s.Start()
 
 	} else {
@@ -14,7 +14,7 @@ import (
 var (
 	mockAlertDB = map[string]*Alert{"jon@labstack.com":
 	&Alert{Name: "btc alert", Email:"jon@labstack.com",
-		Coin: "BTC", ThresholdDelta:.7, TimeDelta:"7d"},
+		CoinSymbol: "BTC", ThresholdDelta:.7, TimeDelta:"7d"},
 	}
 	mockNotificationDB = map[string]*Notification{"jon@labstack.com":
 	&Notification{Email:"jon@labstack.com", CoinName: "Bitcoin", CoinSymbol: "BTC", ThresholdDelta:.7, CurrentDelta:.8},
@@ -100,9 +100,9 @@ func check(e error) {
 
 func TestEmailContentWithNotifications(t *testing.T) {
 	n1 := Notification{Email:"jon@labstack.com", CoinName: "Bitcoin", CoinSymbol: "BTC", ThresholdDelta:.7, CurrentDelta:.8,
-		AlertId: 0, TimeDelta: "7d", LastUpdated:1500965432}
+		AlertId: 0, TimeDelta: "7d", LastUpdated:1500965432, CooldownMinutes: 720}
 	n2 := Notification{Email:"jon@labstack.com", CoinName: "Ethereum", CoinSymbol: "ETH", ThresholdDelta:.7, CurrentDelta:.8,
-		AlertId: 1, TimeDelta: "7d", LastUpdated:1500965432}
+		AlertId: 1, TimeDelta: "7d", LastUpdated:1500965432, CooldownMinutes: 90}
 
 	var notifications []Notification
 	notifications = append(notifications, n1, n2)
@@ -121,3 +121,14 @@ func TestEmailContentWithNotifications(t *testing.T) {
 }
 
 
+
+func TestPriceAlertEmailContent(t *testing.T) {
+	n := Notification{Email: "jon@labstack.com", CoinName: "Bitcoin", CoinSymbol: "BTC", Kind: ALERT_KIND_PRICE,
+		Metric: "price_usd", Direction: DIRECTION_ABOVE, CurrentValue: 70250.5, ThresholdValue: 70000, Currency: "USD",
+		LastUpdated: 1500965432}
+
+	body := prettyPrintNotifications([]string{"BTC over 70k"}, []Notification{n})
+	assert.Contains(t, body, "<b>Current Price</b>: 70250.50 USD")
+	assert.Contains(t, body, "<b>Alert Level</b>: above 70000.00 USD")
+	assert.NotContains(t, body, "% Change")
+}
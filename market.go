@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ALERT_KIND_MARKET watches a global metric computed from the whole ticker
+// list. Like pair alerts it fires on a level (Threshold and Direction) or,
+// when TimeDelta is set, on the change of the metric (ThresholdDelta, with
+// the same signed semantics as change alerts).
+const ALERT_KIND_MARKET = "market"
+
+// Global market metrics. Total market cap changes are in percent, share
+// changes in percentage points and count changes in coins.
+const (
+	MARKET_TOTAL_CAP     = "total_market_cap"
+	MARKET_BTC_DOMINANCE = "btc_dominance"
+	MARKET_TOP10_SHARE   = "top10_share"
+	MARKET_COINS_UP      = "coins_up"
+	MARKET_COINS_DOWN    = "coins_down"
+)
+
+const BTC_COIN_KEY = "BTC_BITCOIN"
+
+// marketMetrics reads each metric off a MarketSnapshot.
+var marketMetrics = map[string]func(MarketSnapshot) float64{
+	MARKET_TOTAL_CAP:     func(m MarketSnapshot) float64 { return m.TotalMarketCap },
+	MARKET_BTC_DOMINANCE: func(m MarketSnapshot) float64 { return m.BTCDominance },
+	MARKET_TOP10_SHARE:   func(m MarketSnapshot) float64 { return m.Top10Share },
+	MARKET_COINS_UP:      func(m MarketSnapshot) float64 { return float64(m.CoinsUp) },
+	MARKET_COINS_DOWN:    func(m MarketSnapshot) float64 { return float64(m.CoinsDown) },
+}
+
+func init() {
+	registerAlertKind(ALERT_KIND_MARKET, alertKind{validateMarketAlert, evaluateMarketAlert})
+}
+
+// computeMarketMetrics aggregates the ticker list into global metrics. Coins
+// without a market cap count towards up/down but not towards the shares.
+func computeMarketMetrics(CoinDeltas map[string]CoinInfo, takenAt time.Time) MarketSnapshot {
+	m := MarketSnapshot{TakenAt: takenAt}
+	var caps []float64
+	for key, coinInfo := range CoinDeltas {
+		if change, err := strconv.ParseFloat(coinInfo.Change24h, 64); err == nil {
+			if change > 0 {
+				m.CoinsUp++
+			} else if change < 0 {
+				m.CoinsDown++
+			}
+		}
+		marketCap, err := strconv.ParseFloat(coinInfo.MarketCapUSD, 64)
+		if err != nil || marketCap <= 0 {
+			continue
+		}
+		caps = append(caps, marketCap)
+		m.TotalMarketCap += marketCap
+		if key == BTC_COIN_KEY {
+			m.BTCDominance = marketCap
+		}
+	}
+	if m.TotalMarketCap == 0 {
+		return m
+	}
+
+	sort.Sort(sort.Reverse(sort.Float64Slice(caps)))
+	var top10 float64
+	for _, c := range caps[:min(10, len(caps))] {
+		top10 += c
+	}
+	m.Top10Share = top10 / m.TotalMarketCap * 100
+	m.BTCDominance = m.BTCDominance / m.TotalMarketCap * 100
+	return m
+}
+
+func insertMarketSnapshot(m MarketSnapshot) error {
+	return db.Create(&m).Error
+}
+
+// marketSnapshotAt returns the latest global snapshot taken at or before t,
+// and no more than maxGap before it.
+func marketSnapshotAt(t time.Time, maxGap time.Duration) (MarketSnapshot, error) {
+	var m MarketSnapshot
+	err := db.Where("taken_at <= ?", t).Order("taken_at desc").First(&m).Error
+	if err != nil {
+		return m, err
+	}
+	if t.Sub(m.TakenAt) > maxGap {
+		return m, fmt.Errorf("no market snapshot near %s (closest %s)", t, m.TakenAt)
+	}
+	return m, nil
+}
+
+// marketChange is the change of a metric between two snapshots, in the
+// metric's change unit.
+func marketChange(metric string, from float64, to float64) float64 {
+	if metric == MARKET_TOTAL_CAP {
+		return percentChange(from, to)
+	}
+	return to - from
+}
+
+func isMarketChangeAlert(alert Alert) bool {
+	return alert.TimeDelta != ""
+}
+
+func validateMarketAlert(alert *Alert) error {
+	alert.Metric = strings.ToLower(alert.Metric)
+	if _, ok := marketMetrics[alert.Metric]; !ok {
+		return fmt.Errorf("unknown market metric %q: expected %s, %s, %s, %s or %s", alert.Metric,
+			MARKET_TOTAL_CAP, MARKET_BTC_DOMINANCE, MARKET_TOP10_SHARE, MARKET_COINS_UP, MARKET_COINS_DOWN)
+	}
+	if isMarketChangeAlert(*alert) {
+		return validateChangeAlert(alert)
+	}
+	if alert.Threshold <= 0 {
+		return errors.New("threshold must be a positive level")
+	}
+	alert.Direction = strings.ToLower(alert.Direction)
+	if alert.Direction != DIRECTION_ABOVE && alert.Direction != DIRECTION_BELOW {
+		return fmt.Errorf("direction must be %q or %q", DIRECTION_ABOVE, DIRECTION_BELOW)
+	}
+	return nil
+}
+
+// marketSummary describes the market for the notification.
+func marketSummary(m MarketSnapshot) string {
+	return fmt.Sprintf("total market cap %s; BTC dominance %.2f%%; top 10 share %.2f%%; %d up, %d down (24h)",
+		formatPrice(m.TotalMarketCap, "USD"), m.BTCDominance, m.Top10Share, m.CoinsUp, m.CoinsDown)
+}
+
+func evaluateMarketAlert(alert Alert, e evaluation) (reading, error) {
+	if e.Market.TotalMarketCap == 0 {
+		return reading{}, errors.New("no market cap data to compute market metrics")
+	}
+	metric := marketMetrics[alert.Metric]
+	value := metric(e.Market)
+
+	notification := Notification{
+		CoinName: "Global market", CoinSymbol: alert.Metric, LastUpdated: e.Now.Unix(),
+		Metric: alert.Metric, CurrentValue: value, Detail: marketSummary(e.Market),
+	}
+
+	var r reading
+	if isMarketChangeAlert(alert) {
+		window, err := parseTimeDelta(alert.TimeDelta)
+		if err != nil {
+			return reading{}, err
+		}
+		past, err := marketSnapshotAt(e.Now.Add(-window), historyMaxGap(window))
+		if err != nil {
+			return reading{}, err
+		}
+		change := marketChange(alert.Metric, metric(past), value)
+		r = levelReading(change, alert.ThresholdDelta, thresholdDirection(alert.ThresholdDelta), alert.Hysteresis)
+		r.Violation = isViolation(change, alert.ThresholdDelta)
+		notification.TimeDelta = alert.TimeDelta
+		notification.CurrentDelta = change
+		notification.ThresholdDelta = alert.ThresholdDelta
+		notification.Baseline = metric(past)
+	} else {
+		r = levelReading(value, alert.Threshold, alert.Direction, alert.Hysteresis)
+		notification.Direction = alert.Direction
+		notification.ThresholdValue = alert.Threshold
+	}
+	if r.Violation {
+		log.Debugf("Violation market alert ID(%d): %s %f", alert.ID, alert.Metric, value)
+	}
+
+	r.Notification = notification
+	return r, nil
+}
+
+// formatMarketMetric renders a metric value in its unit.
+func formatMarketMetric(metric string, value float64) string {
+	switch metric {
+	case MARKET_TOTAL_CAP:
+		return formatPrice(value, "USD")
+	case MARKET_BTC_DOMINANCE, MARKET_TOP10_SHARE:
+		return fmt.Sprintf("%.2f%%", value)
+	}
+	return fmt.Sprintf("%d coins", int(value))
+}
+
+// formatMarketChange renders a metric change in its change unit.
+func formatMarketChange(metric string, change float64) string {
+	switch metric {
+	case MARKET_TOTAL_CAP:
+		return fmt.Sprintf("%+.2f%%", change)
+	case MARKET_BTC_DOMINANCE, MARKET_TOP10_SHARE:
+		return fmt.Sprintf("%+.2f points", change)
+	}
+	return fmt.Sprintf("%+d coins", int(change))
+}
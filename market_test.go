@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func marketFixture() map[string]CoinInfo {
+	coins := []CoinInfo{
+		{Symbol: "BTC", Name: "Bitcoin", MarketCapUSD: "600", Change24h: "1.5"},
+		{Symbol: "ETH", Name: "Ethereum", MarketCapUSD: "200", Change24h: "-2"},
+		{Symbol: "NOCAP", Name: "Nocap", Change24h: "3"},
+	}
+	// Eleven small coins so the top 10 excludes one of them.
+	for i := 0; i < 11; i++ {
+		coins = append(coins, CoinInfo{Symbol: "C" + strconv.Itoa(i), Name: "Coin" + strconv.Itoa(i), MarketCapUSD: "10", Change24h: "0"})
+	}
+	return coinInfoMap(coins)
+}
+
+func TestComputeMarketMetrics(t *testing.T) {
+	m := computeMarketMetrics(marketFixture(), time.Now())
+	assert.Equal(t, 910.0, m.TotalMarketCap)
+	assert.InDelta(t, 65.934, m.BTCDominance, 1e-3)
+	assert.InDelta(t, 96.703, m.Top10Share, 1e-3)
+	assert.Equal(t, 2, m.CoinsUp)
+	assert.Equal(t, 1, m.CoinsDown)
+}
+
+func TestEvaluateMarketLevelAlert(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_MARKET, Metric: "BTC_Dominance", Threshold: 60, Direction: DIRECTION_ABOVE}
+	assert.NoError(t, validateAlert(&alert))
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_MARKET, Metric: "eth_dominance", Threshold: 1, Direction: DIRECTION_ABOVE}))
+	assert.Error(t, validateAlert(&Alert{Kind: ALERT_KIND_MARKET, Metric: MARKET_COINS_UP, TimeDelta: "1d"}))
+
+	e := testEvaluation()
+	e.Market = computeMarketMetrics(marketFixture(), e.Now)
+	r, err := evaluateAlert(alert, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		body := notificationDetail(r.Notification)
+		assert.Contains(t, body, "<b>Current Value</b>: 65.93%")
+		assert.Contains(t, body, "<b>Alert Level</b>: above 60.00%")
+	}
+}
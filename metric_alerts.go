@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Alert kinds on the market metrics carried by CoinInfo.
+const (
+	ALERT_KIND_VOLUME     = "volume"
+	ALERT_KIND_MARKET_CAP = "market_cap"
+	ALERT_KIND_RANK       = "rank"
+)
+
+// Rank alert directions.
+const (
+	DIRECTION_ENTERS = "enters"
+	DIRECTION_EXITS  = "exits"
+)
+
+// Trailing window used for volume spikes when the alert does not set one.
+const DEFAULT_VOLUME_WINDOW = "7d"
+
+// Minimum snapshots in the trailing window before a volume spike is judged.
+const MIN_VOLUME_SAMPLES = 12
+
+func init() {
+	registerAlertKind(ALERT_KIND_VOLUME, alertKind{validateVolumeAlert, evaluateVolumeAlert})
+	registerAlertKind(ALERT_KIND_MARKET_CAP, alertKind{validateMarketCapAlert, evaluateMarketCapAlert})
+	registerAlertKind(ALERT_KIND_RANK, alertKind{validateRankAlert, evaluateRankAlert})
+}
+
+// validateVolumeAlert checks a spike alert: Threshold is the multiple of the
+// trailing average volume over TimeDelta. Volumes are shown in PriceCurrency.
+func validateVolumeAlert(alert *Alert) error {
+	if alert.Threshold <= 1 {
+		return errors.New("threshold must be a volume multiple greater than 1")
+	}
+	if alert.TimeDelta == "" {
+		alert.TimeDelta = DEFAULT_VOLUME_WINDOW
+	}
+	if _, err := parseTimeDelta(alert.TimeDelta); err != nil {
+		return err
+	}
+	return validateQuoteCurrency(&alert.PriceCurrency, false)
+}
+
+func evaluateVolumeAlert(alert Alert, e evaluation) (reading, error) {
+	coinMapKey, coinInfo, err := lookupCoin(alert, e)
+	if err != nil {
+		return reading{}, err
+	}
+	volume, err := strconv.ParseFloat(coinInfo.Volume24, 64)
+	if err != nil {
+		return reading{}, fmt.Errorf("no 24h volume for %s", coinMapKey)
+	}
+
+	window, err := parseTimeDelta(alert.TimeDelta)
+	if err != nil {
+		return reading{}, err
+	}
+	average, samples, err := averageVolume(coinMapKey, e.Now.Add(-window), e.Now)
+	if err != nil {
+		return reading{}, err
+	}
+	if samples < MIN_VOLUME_SAMPLES || average <= 0 {
+		return reading{}, fmt.Errorf("not enough volume history for %s (%d snapshots)", coinMapKey, samples)
+	}
+
+	multiple := volume / average
+	r := levelReading(multiple, alert.Threshold, DIRECTION_ABOVE, alert.Hysteresis)
+	if r.Violation {
+		log.Debugf("Violation %s: volume %f is %.2fx the %s average %f",
+			alert.CoinSymbol, volume, multiple, alert.TimeDelta, average)
+	}
+
+	r.Notification = coinNotification(coinInfo)
+	r.Notification.Metric = "volume_24h"
+	r.Notification.TimeDelta = alert.TimeDelta
+	r.Notification.Direction = DIRECTION_ABOVE
+	currency, rate := e.FX.displayRate(alert.PriceCurrency)
+	r.Notification.CurrentValue = volume * rate
+	r.Notification.ThresholdValue = alert.Threshold
+	r.Notification.Baseline = average * rate
+	r.Notification.Currency = currency
+	setFXRate(&r.Notification, e.FX, rate)
+	return r, nil
+}
+
+func validateMarketCapAlert(alert *Alert) error {
+	if alert.Threshold <= 0 {
+		return errors.New("threshold must be a positive market cap in price_currency")
+	}
+	alert.Direction = strings.ToLower(alert.Direction)
+	if alert.Direction != DIRECTION_ABOVE && alert.Direction != DIRECTION_BELOW {
+		return fmt.Errorf("direction must be %q or %q", DIRECTION_ABOVE, DIRECTION_BELOW)
+	}
+	return validateQuoteCurrency(&alert.PriceCurrency, false)
+}
+
+func evaluateMarketCapAlert(alert Alert, e evaluation) (reading, error) {
+	coinMapKey, coinInfo, err := lookupCoin(alert, e)
+	if err != nil {
+		return reading{}, err
+	}
+	marketCap, err := strconv.ParseFloat(coinInfo.MarketCapUSD, 64)
+	if err != nil {
+		return reading{}, fmt.Errorf("no market cap for %s", coinMapKey)
+	}
+	currency := alert.PriceCurrency
+	if currency == "" {
+		currency = "USD" // created before market caps could be quoted in other currencies.
+	}
+	marketCap, rate, err := e.FX.convertUSD(marketCap, currency)
+	if err != nil {
+		return reading{}, err
+	}
+
+	r := levelReading(marketCap, alert.Threshold, alert.Direction, alert.Hysteresis)
+	if r.Violation {
+		log.Debugf("Violation %s: market cap %f %s %f", alert.CoinSymbol, marketCap, alert.Direction, alert.Threshold)
+	}
+
+	r.Notification = coinNotification(coinInfo)
+	r.Notification.Metric = "market_cap_" + strings.ToLower(currency)
+	r.Notification.Direction = alert.Direction
+	r.Notification.CurrentValue = marketCap
+	r.Notification.ThresholdValue = alert.Threshold
+	r.Notification.Currency = currency
+	setFXRate(&r.Notification, e.FX, rate)
+	return r, nil
+}
+
+// validateRankAlert checks a rank alert: Threshold is N in "enters top N" or
+// "exits top N".
+func validateRankAlert(alert *Alert) error {
+	if alert.Threshold < 1 || alert.Threshold != float64(int(alert.Threshold)) {
+		return errors.New("threshold must be a whole rank of at least 1")
+	}
+	alert.Direction = strings.ToLower(alert.Direction)
+	if alert.Direction != DIRECTION_ENTERS && alert.Direction != DIRECTION_EXITS {
+		return fmt.Errorf("direction must be %q or %q", DIRECTION_ENTERS, DIRECTION_EXITS)
+	}
+	return nil
+}
+
+func evaluateRankAlert(alert Alert, e evaluation) (reading, error) {
+	coinMapKey, coinInfo, err := lookupCoin(alert, e)
+	if err != nil {
+		return reading{}, err
+	}
+	rank, err := strconv.Atoi(coinInfo.Rank)
+	if err != nil || rank < 1 {
+		return reading{}, fmt.Errorf("no rank for %s", coinMapKey)
+	}
+
+	// Ranks are whole numbers, so compare against the midpoint between N and
+	// N+1: entering the top N is dropping below it, exiting is rising above it.
+	boundary := alert.Threshold + 0.5
+	direction := DIRECTION_BELOW
+	if alert.Direction == DIRECTION_EXITS {
+		direction = DIRECTION_ABOVE
+	}
+	r := levelReading(float64(rank), boundary, direction, alert.Hysteresis)
+	if r.Violation {
+		log.Debugf("Violation %s: rank %d %s top %d", alert.CoinSymbol, rank, alert.Direction, int(alert.Threshold))
+	}
+
+	r.Notification = coinNotification(coinInfo)
+	r.Notification.Metric = "rank"
+	r.Notification.Direction = alert.Direction
+	r.Notification.CurrentValue = float64(rank)
+	r.Notification.ThresholdValue = alert.Threshold
+	return r, nil
+}
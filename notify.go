@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/smtp"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/levigross/grequests"
+	ses "stathat.com/c/amzses"
+)
+
+// Message is one user's aggregated notifications, sent over a single channel.
+type Message struct {
+	Email         string
+	Subject       string
+	AlertNames    []string
+	Notifications []Notification
+}
+
+// Notifier delivers messages over one channel. Each adapter renders the
+// message in its channel's own format.
+type Notifier interface {
+	Name() string
+	Send(m Message) error
+}
+
+// NotifierConfig describes a single configured delivery channel. Fields
+// that do not apply to a kind are ignored.
+type NotifierConfig struct {
+	Name       string `json:"name"` // what alerts list in their channels, e.g. "email" or "team-slack".
+	Kind       string `json:"kind"` // ses, smtp, webhook, slack, telegram, discord or sms.
+	URL        string `json:"url"`  // API base for telegram and sms.
+	From       string `json:"from"` // sender address for email channels, sender number for sms.
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	Username   string `json:"username"`    // also the account SID for sms.
+	Password   string `json:"password"`    // also the auth token for sms.
+	Token      string `json:"token"`       // telegram bot token.
+	DailyLimit int    `json:"daily_limit"` // SMS per day across all users, 0 for the default.
+}
+
+type notifierFactory func(config NotifierConfig) Notifier
+
+var notifierRegistry = make(map[string]notifierFactory)
+
+// Active notifiers by name, loaded from configuration at startup.
+var notifiers = make(map[string]Notifier)
+
+const NOTIFIERS_CONFIG_ENV = "NOTIFIERS_CONFIG"
+
+// Channel used by alerts that do not list any.
+const DEFAULT_CHANNEL = "email"
+
+const (
+	ADMIN_EMAIL        = "cryptoalarms@gmail.com"
+	EMAIL_DISPLAY_NAME = "CryptoAlarms Notifications"
+	NOTIFY_TIMEOUT     = 10 * time.Second
+)
+
+func init() {
+	registerNotifier("ses", func(config NotifierConfig) Notifier {
+		return &sesNotifier{config, ses.SendMailHTML}
+	})
+	registerNotifier("smtp", func(config NotifierConfig) Notifier {
+		return &smtpNotifier{config}
+	})
+	registerNotifier("webhook", func(config NotifierConfig) Notifier {
+		return newWebhookNotifier(config)
+	})
+	registerNotifier("slack", func(config NotifierConfig) Notifier {
+		return &slackNotifier{newChatNotifier(config)}
+	})
+	registerNotifier("telegram", func(config NotifierConfig) Notifier {
+		return &telegramNotifier{newChatNotifier(config)}
+	})
+	registerNotifier("discord", func(config NotifierConfig) Notifier {
+		return &discordNotifier{newChatNotifier(config)}
+	})
+	registerNotifier("sms", func(config NotifierConfig) Notifier {
+		return newSMSNotifier(config, &twilioSender{config})
+	})
+}
+
+func registerNotifier(kind string, factory notifierFactory) {
+	notifierRegistry[kind] = factory
+}
+
+func newNotifier(config NotifierConfig) (Notifier, error) {
+	factory, ok := notifierRegistry[config.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown notifier kind: %s", config.Kind)
+	}
+	if config.Name == "" {
+		config.Name = config.Kind
+	}
+	return factory(config), nil
+}
+
+func defaultNotifierConfigs() []NotifierConfig {
+	return []NotifierConfig{{Name: DEFAULT_CHANNEL, Kind: "ses", From: ADMIN_EMAIL}}
+}
+
+// loadNotifierConfigs reads the channel list from the JSON file at path,
+// falling back to SES email when no path is given.
+func loadNotifierConfigs(path string) ([]NotifierConfig, error) {
+	if path == "" {
+		return defaultNotifierConfigs(), nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []NotifierConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return nil, errors.New("no notifiers configured in " + path)
+	}
+	return configs, nil
+}
+
+// buildNotifiers creates the notifiers configured at path, keyed by name.
+func buildNotifiers(path string) (map[string]Notifier, error) {
+	configs, err := loadNotifierConfigs(path)
+	if err != nil {
+		return nil, err
+	}
+	configured := make(map[string]Notifier)
+	for _, config := range configs {
+		n, err := newNotifier(config)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := configured[n.Name()]; ok {
+			return nil, fmt.Errorf("duplicate notifier name: %s", n.Name())
+		}
+		configured[n.Name()] = n
+	}
+	return configured, nil
+}
+
+// configureNotifiers loads the notifiers from NOTIFIERS_CONFIG. A bad
+// configuration is reported and falls back to the default email channel, so
+// notifications keep going out.
+func configureNotifiers() error {
+	configured, err := buildNotifiers(os.Getenv(NOTIFIERS_CONFIG_ENV))
+	if err != nil {
+		configured, _ = buildNotifiers("")
+		err = fmt.Errorf("invalid %s, falling back to email: %s", NOTIFIERS_CONFIG_ENV, err.Error())
+	}
+	notifiers = configured
+	log.Debugf("configured %d notifiers", len(notifiers))
+	return err
+}
+
+// alertChannels returns the notifier names an alert is sent to.
+func alertChannels(channels string) []string {
+	var names []string
+	for _, name := range strings.Split(channels, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{DEFAULT_CHANNEL}
+	}
+	return names
+}
+
+// validateChannels normalizes an alert's channel list and checks that each
+// channel is configured.
+func validateChannels(alert *Alert) error {
+	names := alertChannels(alert.Channels)
+	for _, name := range names {
+		if _, ok := notifiers[name]; !ok {
+			return fmt.Errorf("unknown notification channel %q", name)
+		}
+	}
+	alert.Channels = strings.Join(names, ",")
+	return nil
+}
+
+// newMessage collects a user's notifications into a message. alertNames
+// holds the name of each notification's alert, which need not be unique.
+func newMessage(email string, alertNames []string, notifications []Notification) Message {
+	m := Message{Email: email, AlertNames: alertNames, Notifications: notifications}
+	var coinsArr []string
+	for _, notification := range notifications {
+		coinsArr = append(coinsArr, notification.CoinSymbol)
+	}
+	coinString := strings.Join(coinsArr, ", ")
+	coinString = coinString[0:min(len(coinString), 20)] // cap the string length at 20.
+	m.Subject = fmt.Sprintf("[%s] %s passed change threshold.", EMAIL_DISPLAY_NAME, coinString)
+	return m
+}
+
+// sendMessage delivers a message over the named channel.
+func sendMessage(channel string, m Message) error {
+	n, ok := notifiers[channel]
+	if !ok {
+		return fmt.Errorf("notification channel %q is not configured", channel)
+	}
+	return n.Send(m)
+}
+
+// messageText renders a message as plain text, the fallback for chat
+// clients that cannot show rich messages.
+func messageText(m Message) string {
+	var s []string
+	for i, n := range m.Notifications {
+		lines := []string{fmt.Sprintf("%s: %s (%s)", m.AlertNames[i], n.CoinSymbol, n.CoinName)}
+		for _, f := range notificationFields(n) {
+			lines = append(lines, fmt.Sprintf("  %s: %s", f.Name, f.Value))
+		}
+		dateUpdated, _ := msToTime(n.LastUpdated)
+		lines = append(lines, "  As of time: "+dateUpdated.UTC().Format("2006-01-02 15:04 MST"))
+		s = append(s, strings.Join(lines, "\n"))
+	}
+	return m.Subject + "\n\n" + strings.Join(s, "\n\n")
+}
+
+// postJSON posts payload to url, failing on a non-2xx response.
+func postJSON(url string, payload interface{}) (*grequests.Response, error) {
+	// The URL is left out of errors, since webhook URLs and bot API paths
+	// carry their credentials.
+	resp, err := grequests.Post(url, &grequests.RequestOptions{JSON: payload, RequestTimeout: NOTIFY_TIMEOUT})
+	if err != nil {
+		if urlErr, ok := err.(*neturl.Error); ok {
+			return nil, urlErr.Err
+		}
+		return nil, err
+	}
+	if !resp.Ok {
+		return resp, fmt.Errorf("returned status %d: %.200s", resp.StatusCode, resp.String())
+	}
+	return resp, nil
+}
+
+// sesNotifier sends HTML email through Amazon SES. The SES client has a fixed
+// endpoint, so send can be swapped out in tests.
+type sesNotifier struct {
+	config NotifierConfig
+	send   func(from, to, subject, bodyText, bodyHTML string) (string, error)
+}
+
+func (n *sesNotifier) Name() string {
+	return n.config.Name
+}
+
+func (n *sesNotifier) Send(m Message) error {
+	body := createEmailBodyFromNotifications(m.AlertNames, m.Notifications)
+	res, err := n.send(n.config.From, m.Email, m.Subject, "", body)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Sent email to %s, result: %s", m.Email, res)
+	return nil
+}
+
+// smtpNotifier sends HTML email through a plain SMTP relay.
+type smtpNotifier struct {
+	config NotifierConfig
+}
+
+func (n *smtpNotifier) Name() string {
+	return n.config.Name
+}
+
+func (n *smtpNotifier) Send(m Message) error {
+	port := n.config.Port
+	if port == 0 {
+		port = 25
+	}
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+	}
+	headers := []string{
+		"From: " + EMAIL_DISPLAY_NAME + " <" + n.config.From + ">",
+		"To: " + m.Email,
+		"Subject: " + m.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/html; charset=\"UTF-8\"",
+	}
+	body := createEmailBodyFromNotifications(m.AlertNames, m.Notifications)
+	msg := strings.Join(headers, "\r\n") + "\r\n\r\n" + body
+	return smtp.SendMail(fmt.Sprintf("%s:%d", n.config.Host, port), auth, n.config.From, []string{m.Email}, []byte(msg))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMessage() Message {
+	n := Notification{Kind: ALERT_KIND_PRICE, CoinSymbol: "BTC", CoinName: "Bitcoin", Direction: DIRECTION_ABOVE,
+		CurrentValue: 70250, ThresholdValue: 70000, Currency: "USD", LastUpdated: 1709294400}
+	return newMessage("user@example.com", []string{"BTC over 70k"}, []Notification{n})
+}
+
+// recordingServer is a stand-in for a chat or webhook API that records the
+// last request body and answers with response.
+func recordingServer(response string) (*httptest.Server, *[]byte, *string) {
+	var body []byte
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	return server, &body, &path
+}
+
+// fakeSMTPServer accepts a single SMTP session and returns the message data.
+func fakeSMTPServer(t *testing.T) (string, int, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 fake")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var msg []string
+				for {
+					l, _ := r.ReadString('\n')
+					if l == ".\r\n" || l == "" {
+						break
+					}
+					msg = append(msg, l)
+				}
+				data <- strings.Join(msg, "")
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, data
+}
+
+func TestSESNotifier(t *testing.T) {
+	var from, to, subject, html string
+	n := &sesNotifier{NotifierConfig{Name: "email", From: ADMIN_EMAIL}, func(f, t, s, text, h string) (string, error) {
+		from, to, subject, html = f, t, s, h
+		return "ok", nil
+	}}
+	assert.NoError(t, n.Send(testMessage()))
+	assert.Equal(t, ADMIN_EMAIL, from)
+	assert.Equal(t, "user@example.com", to)
+	assert.Equal(t, "[CryptoAlarms Notifications] BTC passed change threshold.", subject)
+	assert.Contains(t, html, "<b>Current Price</b>: 70250.00 USD")
+
+	n.send = func(f, t, s, text, h string) (string, error) { return "", errors.New("throttled") }
+	assert.Error(t, n.Send(testMessage()))
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	host, port, data := fakeSMTPServer(t)
+	n, _ := newNotifier(NotifierConfig{Kind: "smtp", Host: host, Port: port, From: ADMIN_EMAIL})
+	if assert.NoError(t, n.Send(testMessage())) {
+		msg := <-data
+		assert.Contains(t, msg, "To: user@example.com")
+		assert.Contains(t, msg, "Subject: [CryptoAlarms Notifications] BTC passed change threshold.")
+		assert.Contains(t, msg, "Content-Type: text/html")
+		assert.Contains(t, msg, "<b>Alert Level</b>: above 70000.00 USD")
+	}
+}
+
+// fakeNotifier records the messages it is asked to send.
+type fakeNotifier struct {
+	name string
+	sent []Message
+	err  error
+}
+
+func (n *fakeNotifier) Name() string {
+	return n.name
+}
+
+func (n *fakeNotifier) Send(m Message) error {
+	n.sent = append(n.sent, m)
+	return n.err
+}
+
+func TestValidateChannels(t *testing.T) {
+	alert := Alert{}
+	assert.Error(t, validateChannels(&alert), "no notifiers are configured")
+
+	notifiers = map[string]Notifier{"email": &fakeNotifier{name: "email"}, "slack": &fakeNotifier{name: "slack"}}
+	defer func() { notifiers = make(map[string]Notifier) }()
+	alert.Channels = ""
+	assert.NoError(t, validateChannels(&alert))
+	assert.Equal(t, DEFAULT_CHANNEL, alert.Channels)
+	alert.Channels = " slack, email "
+	assert.NoError(t, validateChannels(&alert))
+	assert.Equal(t, "slack,email", alert.Channels)
+	alert.Channels = "slack,pager"
+	assert.Error(t, validateChannels(&alert))
+}
+
+func TestConfigureNotifiersFallsBackToEmail(t *testing.T) {
+	os.Setenv(NOTIFIERS_CONFIG_ENV, "testdata/missing_notifiers.json")
+	defer os.Unsetenv(NOTIFIERS_CONFIG_ENV)
+	defer func() { notifiers = make(map[string]Notifier) }()
+
+	assert.Error(t, configureNotifiers())
+	assert.Len(t, notifiers, 1)
+	assert.Contains(t, notifiers, DEFAULT_CHANNEL)
+}
+
+func TestLoadNotifierConfigsDefault(t *testing.T) {
+	configs, err := loadNotifierConfigs("")
+	if assert.NoError(t, err) && assert.Len(t, configs, 1) {
+		assert.Equal(t, "ses", configs[0].Kind)
+		assert.Equal(t, DEFAULT_CHANNEL, configs[0].Name)
+	}
+	_, err = newNotifier(NotifierConfig{Kind: "pigeon"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Outbox entry states. Dead entries exhausted their retries and are kept
+// with their last error for inspection.
+const (
+	OUTBOX_PENDING   = "pending"
+	OUTBOX_DELIVERED = "delivered"
+	OUTBOX_DEAD      = "dead"
+)
+
+// Minutes between dispatcher runs, which send retries and anything left
+// queued by a previous process.
+const OUTBOX_DISPATCH_INTERVAL = 1
+
+// Entries sent per dispatcher run; the rest wait for the next run.
+const OUTBOX_BATCH = 500
+
+// OutboxPolicy bounds retries of a failed outbox delivery.
+type OutboxPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration // delay after the first failure, doubled after each one.
+	MaxBackoff  time.Duration
+}
+
+var outboxPolicy = OutboxPolicy{MaxAttempts: 8, Backoff: time.Minute, MaxBackoff: time.Hour}
+
+// Serializes dispatcher runs so an entry is never sent twice at once.
+var outboxMutex sync.Mutex
+
+// outboxEntries returns one pending entry per channel the notification is
+// routed to.
+func outboxEntries(n Notification, alertName string, now time.Time) []OutboxMessage {
+	var entries []OutboxMessage
+	for _, channel := range alertChannels(n.Channels) {
+		entries = append(entries, OutboxMessage{NotificationID: n.ID, AlertID: n.AlertId, Email: n.Email,
+			AlertName: alertName, Channel: channel, Status: OUTBOX_PENDING, NextAttemptAt: now})
+	}
+	return entries
+}
+
+// insertNotification stores a notification, queues it for delivery and saves
+// the state of the alert that fired it in a single transaction, so the alert
+// is never disarmed for a notification that was not queued.
+func insertNotification(n *Notification, alert Alert) error {
+	log.Debugf("Inserting notification: %s", n)
+	tx := db.Begin()
+	if err := tx.Create(n).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Model(&Alert{}).Where("id = ?", alert.ID).UpdateColumns(alertState(alert)).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, entry := range outboxEntries(*n, alert.Name, time.Now()) {
+		if err := tx.Create(&entry).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// backfillDeliveredAt marks notifications stored before deliveries were
+// tracked as delivered when they were created, so their alerts keep their
+// cooldowns.
+func backfillDeliveredAt() error {
+	return db.Table("notifications").Where("delivered_at IS NULL").
+		UpdateColumn("delivered_at", gorm.Expr("created_at")).Error
+}
+
+// outboxPending reports whether an alert has a notification still waiting
+// to be delivered, so it does not fire again while retries are under way.
+func outboxPending(alertID uint) bool {
+	var count int
+	err := db.Model(&OutboxMessage{}).Where("alert_id = ? AND status = ?", alertID, OUTBOX_PENDING).Count(&count).Error
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+	return count > 0
+}
+
+// outboxBackoff is the delay before retrying an entry that has failed
+// attempts times.
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxPolicy.Backoff
+	for i := 1; i < attempts && backoff < outboxPolicy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxPolicy.MaxBackoff {
+		backoff = outboxPolicy.MaxBackoff
+	}
+	return backoff
+}
+
+// markOutboxAttempt records the outcome of a delivery attempt, scheduling a
+// retry or dead-lettering the entry when it fails.
+func markOutboxAttempt(entry *OutboxMessage, err error, now time.Time) {
+	entry.Attempts++
+	if err == nil {
+		entry.Status, entry.DeliveredAt, entry.LastError = OUTBOX_DELIVERED, &now, ""
+		return
+	}
+	entry.LastError = err.Error()
+	if entry.Attempts >= outboxPolicy.MaxAttempts {
+		entry.Status = OUTBOX_DEAD
+		log.Errorf("dead-lettered notification %d to %s over %s after %d attempts: %s",
+			entry.NotificationID, entry.Email, entry.Channel, entry.Attempts, entry.LastError)
+		return
+	}
+	entry.NextAttemptAt = now.Add(outboxBackoff(entry.Attempts))
+}
+
+type outboxKey struct {
+	Email   string
+	Channel string
+}
+
+// groupOutbox batches entries by user and channel, so each user gets one
+// message per channel as before.
+func groupOutbox(entries []OutboxMessage) (map[outboxKey][]OutboxMessage, []outboxKey) {
+	groups := make(map[outboxKey][]OutboxMessage)
+	var keys []outboxKey
+	for _, entry := range entries {
+		key := outboxKey{entry.Email, entry.Channel}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], entry)
+	}
+	return groups, keys
+}
+
+func dueOutboxMessages(now time.Time) ([]OutboxMessage, error) {
+	var entries []OutboxMessage
+	err := db.Where("status = ? AND next_attempt_at <= ?", OUTBOX_PENDING, now).Order("id").
+		Limit(OUTBOX_BATCH).Find(&entries).Error
+	return entries, err
+}
+
+func outboxNotifications(entries []OutboxMessage) (map[uint]Notification, error) {
+	var ids []uint
+	for _, entry := range entries {
+		ids = append(ids, entry.NotificationID)
+	}
+	var notifications []Notification
+	if err := db.Where("id IN (?)", ids).Find(&notifications).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]Notification)
+	for _, n := range notifications {
+		byID[n.ID] = n
+	}
+	return byID, nil
+}
+
+func saveOutboxAttempt(entry *OutboxMessage) error {
+	tx := db.Begin()
+	if err := tx.Save(entry).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if entry.Status == OUTBOX_DELIVERED {
+		err := tx.Model(&Notification{}).Where("id = ? AND delivered_at IS NULL", entry.NotificationID).
+			Update("delivered_at", entry.DeliveredAt).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// dispatchOutbox sends the entries that are due, one message per user and
+// channel, and returns how many entries were delivered and how many failed.
+func dispatchOutbox(now time.Time) (int, int) {
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+	entries, err := dueOutboxMessages(now)
+	if err != nil {
+		log.Error("could not load the notification outbox: ", err.Error())
+		return 0, 0
+	}
+	if len(entries) == 0 {
+		return 0, 0
+	}
+	notifications, err := outboxNotifications(entries)
+	if err != nil {
+		log.Error("could not load queued notifications: ", err.Error())
+		return 0, 0
+	}
+	var delivered, failed int
+	groups, keys := groupOutbox(entries)
+	for _, key := range keys {
+		var sendable []OutboxMessage
+		var alertNames []string
+		var batch []Notification
+		for _, entry := range groups[key] {
+			n, ok := notifications[entry.NotificationID]
+			if !ok {
+				// Deleted by the user before it could be sent; there is nothing to retry.
+				entry.Status, entry.LastError = OUTBOX_DEAD, "notification no longer exists"
+				if err := saveOutboxAttempt(&entry); err != nil {
+					log.Errorf("could not update outbox entry %d: %s", entry.ID, err.Error())
+				}
+				failed++
+				continue
+			}
+			alertNames = append(alertNames, entry.AlertName)
+			batch = append(batch, n)
+			sendable = append(sendable, entry)
+		}
+		if len(sendable) == 0 {
+			continue
+		}
+		err := sendMessage(key.Channel, newMessage(key.Email, alertNames, batch))
+		if err != nil {
+			log.Errorf("%s: sending over %s failed: %s", key.Email, key.Channel, err.Error())
+			failed += len(sendable)
+		} else {
+			log.Debugf("Sent %d notifications to %s over %s", len(sendable), key.Email, key.Channel)
+			delivered += len(sendable)
+		}
+		for i := range sendable {
+			markOutboxAttempt(&sendable[i], err, now)
+			if saveErr := saveOutboxAttempt(&sendable[i]); saveErr != nil {
+				log.Errorf("could not update outbox entry %d: %s", sendable[i].ID, saveErr.Error())
+			}
+		}
+	}
+	return delivered, failed
+}
+
+func runOutboxDispatcher() {
+	delivered, failed := dispatchOutbox(time.Now())
+	if delivered+failed > 0 {
+		log.Debugf("outbox: %d delivered, %d failed", delivered, failed)
+	}
+}
+
+func messageNotificationIDs(m Message) []uint {
+	ids := make([]uint, len(m.Notifications))
+	for i, n := range m.Notifications {
+		ids[i] = n.ID
+	}
+	return ids
+}
+
+// receivedNotifications returns which of ids already reached target over
+// channel.
+func receivedNotifications(channel string, targetID uint, ids []uint) (map[uint]bool, error) {
+	var receipts []DeliveryReceipt
+	err := db.Where("channel = ? AND target_id = ? AND notification_id IN (?)", channel, targetID, ids).
+		Find(&receipts).Error
+	received := make(map[uint]bool)
+	for _, r := range receipts {
+		received[r.NotificationID] = true
+	}
+	return received, err
+}
+
+func recordReceipts(channel string, targetID uint, ids []uint) error {
+	tx := db.Begin()
+	for _, id := range ids {
+		if err := tx.Create(&DeliveryReceipt{NotificationID: id, Channel: channel, TargetID: targetID}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// deliveryReceipts tracks what each target of a channel has received, for
+// notifiers that send one outbox entry to several targets.
+type deliveryReceipts struct {
+	received func(channel string, targetID uint, ids []uint) (map[uint]bool, error)
+	record   func(channel string, targetID uint, ids []uint) error
+}
+
+var storedReceipts = deliveryReceipts{receivedNotifications, recordReceipts}
+
+// sendMissing calls send with the part of m that the target has not
+// received yet, if any, and records the receipts when it succeeds.
+func (r deliveryReceipts) sendMissing(channel string, targetID uint, m Message, send func(m Message) error) error {
+	received, err := r.received(channel, targetID, messageNotificationIDs(m))
+	if err != nil {
+		return err
+	}
+	var alertNames []string
+	var missing []Notification
+	for i, n := range m.Notifications {
+		if !received[n.ID] {
+			alertNames = append(alertNames, m.AlertNames[i])
+			missing = append(missing, n)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	part := m
+	if len(missing) < len(m.Notifications) {
+		part = newMessage(m.Email, alertNames, missing)
+	}
+	if err := send(part); err != nil {
+		return err
+	}
+	if err := r.record(channel, targetID, messageNotificationIDs(part)); err != nil {
+		log.Errorf("could not record delivery over %s to target %d: %s", channel, targetID, err.Error())
+	}
+	return nil
+}
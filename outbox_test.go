@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxRoutesByChannel(t *testing.T) {
+	now := time.Now()
+	var entries []OutboxMessage
+	entries = append(entries, outboxEntries(Notification{Email: "a@example.com"}, "btc", now)...)
+	entries = append(entries, outboxEntries(Notification{Email: "a@example.com", Channels: "email,slack"}, "eth", now)...)
+	entries = append(entries, outboxEntries(Notification{Email: "a@example.com", Channels: "slack"}, "doge", now)...)
+	entries = append(entries, outboxEntries(Notification{Email: "b@example.com"}, "xrp", now)...)
+
+	groups, keys := groupOutbox(entries)
+	assert.Equal(t, []outboxKey{{"a@example.com", "email"}, {"a@example.com", "slack"}, {"b@example.com", "email"}}, keys)
+	names := func(key outboxKey) []string {
+		var names []string
+		for _, entry := range groups[key] {
+			assert.Equal(t, OUTBOX_PENDING, entry.Status)
+			names = append(names, entry.AlertName)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"btc", "eth"}, names(keys[0]))
+	assert.Equal(t, []string{"eth", "doge"}, names(keys[1]))
+	assert.Equal(t, []string{"xrp"}, names(keys[2]))
+}
+
+func TestMarkOutboxAttempt(t *testing.T) {
+	now := time.Now()
+	entry := OutboxMessage{Status: OUTBOX_PENDING}
+	markOutboxAttempt(&entry, errors.New("down"), now)
+	assert.Equal(t, OUTBOX_PENDING, entry.Status)
+	assert.Equal(t, now.Add(outboxPolicy.Backoff), entry.NextAttemptAt)
+	assert.Equal(t, "down", entry.LastError)
+
+	markOutboxAttempt(&entry, errors.New("down"), now)
+	assert.Equal(t, now.Add(2*outboxPolicy.Backoff), entry.NextAttemptAt)
+
+	markOutboxAttempt(&entry, nil, now)
+	assert.Equal(t, OUTBOX_DELIVERED, entry.Status)
+	assert.Equal(t, &now, entry.DeliveredAt)
+	assert.Empty(t, entry.LastError)
+
+	entry = OutboxMessage{Status: OUTBOX_PENDING}
+	for i := 0; i < outboxPolicy.MaxAttempts; i++ {
+		assert.Equal(t, OUTBOX_PENDING, entry.Status)
+		markOutboxAttempt(&entry, errors.New("down"), now)
+	}
+	assert.Equal(t, OUTBOX_DEAD, entry.Status)
+	assert.Equal(t, outboxPolicy.MaxAttempts, entry.Attempts)
+	assert.Equal(t, outboxPolicy.MaxBackoff, outboxBackoff(20))
+}
+
+// memoryReceipts keeps delivery receipts in memory.
+func memoryReceipts() deliveryReceipts {
+	receipts := make(map[string]bool)
+	key := func(channel string, targetID uint, id uint) string {
+		return fmt.Sprintf("%s/%d/%d", channel, targetID, id)
+	}
+	return deliveryReceipts{
+		received: func(channel string, targetID uint, ids []uint) (map[uint]bool, error) {
+			received := make(map[uint]bool)
+			for _, id := range ids {
+				received[id] = receipts[key(channel, targetID, id)]
+			}
+			return received, nil
+		},
+		record: func(channel string, targetID uint, ids []uint) error {
+			for _, id := range ids {
+				receipts[key(channel, targetID, id)] = true
+			}
+			return nil
+		},
+	}
+}
+
+func TestDeliveryReceiptsSendMissing(t *testing.T) {
+	m := manyAlerts(3)
+	for i := range m.Notifications {
+		m.Notifications[i].ID = uint(i + 1)
+	}
+	r := memoryReceipts()
+	r.record("slack", 7, []uint{2})
+
+	var sent []Message
+	send := func(part Message) error { sent = append(sent, part); return nil }
+	assert.NoError(t, r.sendMissing("slack", 7, m, send))
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, []uint{1, 3}, messageNotificationIDs(sent[0]))
+		assert.Equal(t, []string{"BTC over 70k", "alert 2"}, sent[0].AlertNames)
+	}
+	assert.NoError(t, r.sendMissing("slack", 7, m, send))
+	assert.Len(t, sent, 1, "nothing left to send")
+
+	// Another target, or a failed send, gets everything again.
+	failing := func(part Message) error { return errors.New("down") }
+	assert.Error(t, r.sendMissing("slack", 8, m, failing))
+	assert.NoError(t, r.sendMissing("slack", 8, m, send))
+	if assert.Len(t, sent, 2) {
+		assert.Len(t, sent[1].Notifications, 3)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ALERT_KIND_PAIR watches the ratio of two coins' USD prices, e.g. ETH/BTC.
+// It fires on a ratio level (Threshold and Direction) or, when TimeDelta is
+// set, on the percent change of the ratio (ThresholdDelta).
+const ALERT_KIND_PAIR = "pair"
+
+func init() {
+	registerAlertKind(ALERT_KIND_PAIR, alertKind{validatePairAlert, evaluatePairAlert})
+}
+
+func isPairChangeAlert(alert Alert) bool {
+	return alert.TimeDelta != ""
+}
+
+func validatePairAlert(alert *Alert) error {
+	if alert.QuoteID == "" && alert.QuoteSymbol == "" {
+		return errors.New("pair alerts need quote_id or quote_symbol")
+	}
+	// Coins given by ID are told apart once resolveAlertCoins fills them in.
+	if alert.QuoteSymbol != "" && createCoinKey(alert.CoinSymbol, alert.CoinName) == createCoinKey(alert.QuoteSymbol, alert.QuoteName) {
+		return errors.New("pair alerts need two different coins")
+	}
+	if isPairChangeAlert(*alert) {
+		return validateChangeAlert(alert)
+	}
+	if alert.Threshold <= 0 {
+		return errors.New("threshold must be a positive ratio")
+	}
+	alert.Direction = strings.ToLower(alert.Direction)
+	if alert.Direction != DIRECTION_ABOVE && alert.Direction != DIRECTION_BELOW {
+		return fmt.Errorf("direction must be %q or %q", DIRECTION_ABOVE, DIRECTION_BELOW)
+	}
+	return nil
+}
+
+func priceRatio(base CoinInfo, quote CoinInfo) (float64, error) {
+	basePrice, err := strconv.ParseFloat(base.PriceUSD, 64)
+	if err != nil {
+		return 0, err
+	}
+	quotePrice, err := strconv.ParseFloat(quote.PriceUSD, 64)
+	if err != nil || quotePrice <= 0 {
+		return 0, fmt.Errorf("no usable price for %s", quote.Symbol)
+	}
+	return basePrice / quotePrice, nil
+}
+
+// historicalRatio returns the pair ratio from the snapshots stored at t,
+// allowing them to lag t by maxGap.
+func historicalRatio(baseKey string, quoteKey string, t time.Time, maxGap time.Duration) (float64, error) {
+	base, err := snapshotAt(baseKey, t, maxGap)
+	if err != nil {
+		return 0, err
+	}
+	quote, err := snapshotAt(quoteKey, t, maxGap)
+	if err != nil {
+		return 0, err
+	}
+	return snapshotRatio(base, quote)
+}
+
+// snapshotRatio is the pair ratio between two stored snapshots. A zero base
+// price is rejected too, since change alerts divide by the past ratio.
+func snapshotRatio(base PriceSnapshot, quote PriceSnapshot) (float64, error) {
+	if base.PriceUSD <= 0 {
+		return 0, fmt.Errorf("no usable price for %s at %s", base.CoinKey, base.TakenAt)
+	}
+	if quote.PriceUSD <= 0 {
+		return 0, fmt.Errorf("no usable price for %s at %s", quote.CoinKey, quote.TakenAt)
+	}
+	return base.PriceUSD / quote.PriceUSD, nil
+}
+
+func evaluatePairAlert(alert Alert, e evaluation) (reading, error) {
+	baseKey, base, err := lookupCoin(alert, e)
+	if err != nil {
+		return reading{}, err
+	}
+	quoteKey, quote, err := lookupCoinQuote(alert, alert.QuoteID, alert.QuoteSymbol, alert.QuoteName, e)
+	if err != nil {
+		return reading{}, err
+	}
+	ratio, err := priceRatio(base, quote)
+	if err != nil {
+		return reading{}, err
+	}
+
+	notification := coinNotification(base)
+	notification.CoinSymbol = base.Symbol + "/" + quote.Symbol
+	notification.CoinName = base.Name + "/" + quote.Name
+	notification.Metric = "ratio"
+	notification.CurrentValue = ratio
+	notification.Sources = strings.Join(uniqueStrings(append(append([]string(nil), base.Sources...), quote.Sources...)), ",")
+
+	var r reading
+	if isPairChangeAlert(alert) {
+		window, err := parseTimeDelta(alert.TimeDelta)
+		if err != nil {
+			return reading{}, err
+		}
+		past, err := historicalRatio(baseKey, quoteKey, e.Now.Add(-window), historyMaxGap(window))
+		if err != nil {
+			return reading{}, err
+		}
+		change := percentChange(past, ratio)
+		r = levelReading(change, alert.ThresholdDelta, thresholdDirection(alert.ThresholdDelta), alert.Hysteresis)
+		r.Violation = isViolation(change, alert.ThresholdDelta)
+		notification.TimeDelta = alert.TimeDelta
+		notification.CurrentDelta = change
+		notification.ThresholdDelta = alert.ThresholdDelta
+		notification.Baseline = past
+	} else {
+		r = levelReading(ratio, alert.Threshold, alert.Direction, alert.Hysteresis)
+		notification.Direction = alert.Direction
+		notification.ThresholdValue = alert.Threshold
+	}
+	if r.Violation {
+		log.Debugf("Violation %s: ratio %f", notification.CoinSymbol, ratio)
+	}
+
+	r.Notification = notification
+	return r, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Plan limits how aggressively a user's alerts may notify.
+type Plan struct {
+	Name          string
+	MinCooldown   time.Duration
+	SMSDailyQuota int // SMS messages per UTC day, verification codes included.
+}
+
+const (
+	PLAN_FREE = "free"
+	PLAN_PRO  = "pro"
+	PLAN_DESK = "desk"
+)
+
+// Cooldown applied to alerts that do not set one.
+const DEFAULT_COOLDOWN = 12 * time.Hour
+
+var plans = map[string]Plan{
+	PLAN_FREE: {PLAN_FREE, 12 * time.Hour, 5},
+	PLAN_PRO:  {PLAN_PRO, time.Hour, 25},
+	PLAN_DESK: {PLAN_DESK, 5 * time.Minute, 100},
+}
+
+// userPlan returns the plan for email, defaulting to free when the user has
+// no account row or an unknown plan.
+func userPlan(email string) Plan {
+	var user User
+	if err := db.Where("email = ?", email).First(&user).Error; err == nil {
+		if plan, ok := plans[user.Plan]; ok {
+			return plan
+		}
+	}
+	return plans[PLAN_FREE]
+}
+
+func alertCooldown(alert Alert) time.Duration {
+	if alert.CooldownMinutes == 0 {
+		return DEFAULT_COOLDOWN
+	}
+	return time.Duration(alert.CooldownMinutes) * time.Minute
+}
+
+func validateCooldown(alert *Alert, plan Plan) error {
+	if alert.CooldownMinutes < 0 {
+		return fmt.Errorf("cooldown_minutes must not be negative")
+	}
+	if cooldown := alertCooldown(*alert); cooldown < plan.MinCooldown {
+		return fmt.Errorf("cooldown of %s is below the %s plan minimum of %s",
+			formatCooldown(int(cooldown/time.Minute)), plan.Name, formatCooldown(int(plan.MinCooldown/time.Minute)))
+	}
+	return nil
+}
+
+// formatCooldown renders a cooldown for users, e.g. "12 hours" or "90 minutes".
+func formatCooldown(minutes int) string {
+	if minutes%60 == 0 {
+		if minutes == 60 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", minutes/60)
+	}
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
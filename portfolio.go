@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Portfolio alert kinds, evaluated on the holdings of the alert's user.
+const (
+	ALERT_KIND_PORTFOLIO_VALUE  = "portfolio_value"  // Threshold is a total value in PriceCurrency.
+	ALERT_KIND_POSITION_PNL     = "position_pnl"     // ThresholdDelta is a signed P&L percent on the alert's coin, measured in USD like CostBasis.
+	ALERT_KIND_ALLOCATION_DRIFT = "allocation_drift" // Threshold is the allowed drift from target, in percentage points.
+)
+
+// PositionSummary is one holding as valued on a tick.
+type PositionSummary struct {
+	Symbol     string  `json:"symbol"`
+	Quantity   float64 `json:"quantity"`
+	Value      float64 `json:"value"`
+	PnLPercent float64 `json:"pnl_percent"`
+	Allocation float64 `json:"allocation"`
+	Target     float64 `json:"target,omitempty"`
+}
+
+// PortfolioSummary is a user's portfolio as valued on a tick, in Currency.
+// It is stored as JSON on portfolio notifications for the email table.
+type PortfolioSummary struct {
+	Currency  string            `json:"currency"`
+	Total     float64           `json:"total"`
+	Cost      float64           `json:"cost"`
+	Positions []PositionSummary `json:"positions"`
+}
+
+func init() {
+	registerAlertKind(ALERT_KIND_PORTFOLIO_VALUE, alertKind{validatePortfolioValueAlert, evaluatePortfolioValueAlert})
+	registerAlertKind(ALERT_KIND_POSITION_PNL, alertKind{validatePositionPnLAlert, evaluatePositionPnLAlert})
+	registerAlertKind(ALERT_KIND_ALLOCATION_DRIFT, alertKind{validateAllocationDriftAlert, evaluateAllocationDriftAlert})
+}
+
+func (s PortfolioSummary) Value() (driver.Value, error) {
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+func (s *PortfolioSummary) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	}
+	return fmt.Errorf("cannot scan %T into PortfolioSummary", src)
+}
+
+func (s PortfolioSummary) PnLPercent() float64 {
+	if s.Cost <= 0 {
+		return 0
+	}
+	return percentChange(s.Cost, s.Total)
+}
+
+// loadHoldings returns every user's holdings, keyed by email.
+func loadHoldings() (map[string][]Holding, error) {
+	var holdings []Holding
+	if err := db.Order("id").Find(&holdings).Error; err != nil {
+		return nil, err
+	}
+	byEmail := make(map[string][]Holding)
+	for _, h := range holdings {
+		byEmail[h.Email] = append(byEmail[h.Email], h)
+	}
+	return byEmail, nil
+}
+
+// validateHolding checks a holding and pins it to its catalog coin.
+func validateHolding(h *Holding) error {
+	if h.Email == "" {
+		return errors.New("missing email")
+	}
+	if h.Quantity <= 0 {
+		return errors.New("quantity must be positive")
+	}
+	if h.CostBasis < 0 {
+		return errors.New("cost_basis must not be negative")
+	}
+	if h.TargetAllocation < 0 || h.TargetAllocation > 100 {
+		return errors.New("target_allocation must be a percent between 0 and 100")
+	}
+	coin, err := resolveCoinRef(h.CoinID, h.CoinSymbol, h.CoinName)
+	if err != nil {
+		return err
+	}
+	h.CoinID, h.CoinSymbol, h.CoinName = coin.CoinID, coin.Symbol, coin.Name
+
+	var targets struct{ Total float64 }
+	db.Table("holdings").Select("COALESCE(SUM(target_allocation), 0) AS total").
+		Where("email = ? AND id <> ? AND deleted_at IS NULL", h.Email, h.ID).Scan(&targets)
+	if targets.Total+h.TargetAllocation > 100 {
+		return fmt.Errorf("target allocations would add up to %s%%", formatFloat(targets.Total+h.TargetAllocation))
+	}
+	return nil
+}
+
+// valuePortfolio values holdings at this tick's prices in currency. Any
+// missing or stale quote fails the whole valuation rather than understating it.
+func valuePortfolio(alert Alert, holdings []Holding, currency string, e evaluation) (PortfolioSummary, float64, error) {
+	summary := PortfolioSummary{Currency: currency}
+	if len(holdings) == 0 {
+		return summary, 0, fmt.Errorf("%s has no holdings", alert.Email)
+	}
+	var rate float64
+	for _, h := range holdings {
+		_, coinInfo, err := lookupCoinQuote(alert, h.CoinID, h.CoinSymbol, h.CoinName, e)
+		if err != nil {
+			return summary, 0, err
+		}
+		price, err := strconv.ParseFloat(coinInfo.PriceUSD, 64)
+		if err != nil {
+			return summary, 0, fmt.Errorf("no price_usd quote for %s", h.CoinSymbol)
+		}
+		value, r, err := e.FX.convertUSD(price*h.Quantity, currency)
+		if err != nil {
+			return summary, 0, err
+		}
+		cost, _, _ := e.FX.convertUSD(h.CostBasis, currency)
+		rate = r
+
+		p := PositionSummary{Symbol: h.CoinSymbol, Quantity: h.Quantity, Value: value, Target: h.TargetAllocation}
+		if cost > 0 {
+			p.PnLPercent = percentChange(cost, value)
+		}
+		summary.Positions = append(summary.Positions, p)
+		summary.Total += value
+		summary.Cost += cost
+	}
+	for i := range summary.Positions {
+		if summary.Total > 0 {
+			summary.Positions[i].Allocation = summary.Positions[i].Value / summary.Total * 100
+		}
+	}
+	return summary, rate, nil
+}
+
+// portfolioNotification starts a notification for a portfolio-wide alert.
+func portfolioNotification(summary PortfolioSummary, e evaluation, rate float64) Notification {
+	n := Notification{
+		CoinName: "Portfolio", CoinSymbol: fmt.Sprintf("%d positions", len(summary.Positions)),
+		LastUpdated: e.Now.Unix(), Currency: summary.Currency, Portfolio: &summary,
+	}
+	setFXRate(&n, e.FX, rate)
+	return n
+}
+
+func validatePortfolioValueAlert(alert *Alert) error {
+	if alert.Threshold <= 0 {
+		return errors.New("threshold must be a positive portfolio value")
+	}
+	alert.Direction = strings.ToLower(alert.Direction)
+	if alert.Direction != DIRECTION_ABOVE && alert.Direction != DIRECTION_BELOW {
+		return fmt.Errorf("direction must be %q or %q", DIRECTION_ABOVE, DIRECTION_BELOW)
+	}
+	return validateQuoteCurrency(&alert.PriceCurrency, false)
+}
+
+func evaluatePortfolioValueAlert(alert Alert, e evaluation) (reading, error) {
+	summary, rate, err := valuePortfolio(alert, e.Holdings[alert.Email], alert.PriceCurrency, e)
+	if err != nil {
+		return reading{}, err
+	}
+	r := levelReading(summary.Total, alert.Threshold, alert.Direction, alert.Hysteresis)
+	if r.Violation {
+		log.Debugf("Violation portfolio of %s: value %f %s %f %s",
+			alert.Email, summary.Total, alert.Direction, alert.Threshold, alert.PriceCurrency)
+	}
+
+	r.Notification = portfolioNotification(summary, e, rate)
+	r.Notification.Metric = "portfolio_value"
+	r.Notification.Direction = alert.Direction
+	r.Notification.CurrentValue = summary.Total
+	r.Notification.ThresholdValue = alert.Threshold
+	return r, nil
+}
+
+func validatePositionPnLAlert(alert *Alert) error {
+	if alert.CoinSymbol == "" && alert.CoinID == "" {
+		return errors.New("position_pnl alerts need the coin of the position")
+	}
+	if alert.ThresholdDelta == 0 {
+		return errors.New("threshold_delta must be a non-zero P&L percent")
+	}
+	return validateQuoteCurrency(&alert.PriceCurrency, false)
+}
+
+func evaluatePositionPnLAlert(alert Alert, e evaluation) (reading, error) {
+	holdings := e.Holdings[alert.Email]
+	summary, rate, err := valuePortfolio(alert, holdings, alert.PriceCurrency, e)
+	if err != nil {
+		return reading{}, err
+	}
+	position := -1
+	for i, h := range holdings {
+		if (alert.CoinID != "" && h.CoinID == alert.CoinID) ||
+			(alert.CoinID == "" && createCoinKey(h.CoinSymbol, h.CoinName) == createCoinKey(alert.CoinSymbol, alert.CoinName)) {
+			position = i
+			break
+		}
+	}
+	if position < 0 {
+		return reading{}, fmt.Errorf("%s holds no %s", alert.Email, alert.CoinSymbol)
+	}
+	if holdings[position].CostBasis <= 0 {
+		return reading{}, fmt.Errorf("%s position has no cost basis", alert.CoinSymbol)
+	}
+
+	pnl := summary.Positions[position].PnLPercent
+	r := levelReading(pnl, alert.ThresholdDelta, thresholdDirection(alert.ThresholdDelta), alert.Hysteresis)
+	if r.Violation {
+		log.Debugf("Violation %s position of %s: P&L %.2f%% (threshold %.2f%%)", alert.CoinSymbol, alert.Email, pnl, alert.ThresholdDelta)
+	}
+
+	r.Notification = portfolioNotification(summary, e, rate)
+	r.Notification.CoinName, r.Notification.CoinSymbol = holdings[position].CoinName, holdings[position].CoinSymbol
+	r.Notification.Metric = "position_pnl"
+	r.Notification.CurrentDelta = pnl
+	r.Notification.ThresholdDelta = alert.ThresholdDelta
+	r.Notification.CurrentValue = summary.Positions[position].Value
+	return r, nil
+}
+
+func validateAllocationDriftAlert(alert *Alert) error {
+	if alert.Threshold <= 0 || alert.Threshold >= 100 {
+		return errors.New("threshold must be a drift between 0 and 100 percentage points")
+	}
+	return validateQuoteCurrency(&alert.PriceCurrency, false)
+}
+
+func evaluateAllocationDriftAlert(alert Alert, e evaluation) (reading, error) {
+	summary, rate, err := valuePortfolio(alert, e.Holdings[alert.Email], alert.PriceCurrency, e)
+	if err != nil {
+		return reading{}, err
+	}
+	var drift float64
+	var drifted []string
+	targets := 0
+	for _, p := range summary.Positions {
+		if p.Target == 0 {
+			continue
+		}
+		targets++
+		d := p.Allocation - p.Target
+		drift = math.Max(drift, math.Abs(d))
+		if math.Abs(d) > alert.Threshold {
+			drifted = append(drifted, fmt.Sprintf("%s %.1f%% (target %s%%)", p.Symbol, p.Allocation, formatFloat(p.Target)))
+		}
+	}
+	if targets == 0 {
+		return reading{}, fmt.Errorf("%s has no target allocations", alert.Email)
+	}
+
+	r := levelReading(drift, alert.Threshold, DIRECTION_ABOVE, alert.Hysteresis)
+	if r.Violation {
+		log.Debugf("Violation portfolio of %s: allocation drift %.2f points", alert.Email, drift)
+	}
+
+	r.Notification = portfolioNotification(summary, e, rate)
+	r.Notification.Metric = "allocation_drift"
+	r.Notification.CurrentValue = drift
+	r.Notification.ThresholdValue = alert.Threshold
+	r.Notification.Detail = strings.Join(drifted, "; ")
+	return r, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func portfolioEvaluation() evaluation {
+	e := testEvaluation(CoinInfo{ID: "bitcoin", Symbol: "BTC", Name: "Bitcoin", PriceUSD: "60000"},
+		CoinInfo{ID: "ethereum", Symbol: "ETH", Name: "Ethereum", PriceUSD: "3000"})
+	e.Holdings = map[string][]Holding{"a@b.c": {
+		{Email: "a@b.c", CoinID: "bitcoin", CoinSymbol: "BTC", CoinName: "Bitcoin", Quantity: 0.5, CostBasis: 20000, TargetAllocation: 50},
+		{Email: "a@b.c", CoinID: "ethereum", CoinSymbol: "ETH", CoinName: "Ethereum", Quantity: 10, CostBasis: 40000, TargetAllocation: 50},
+	}}
+	return e
+}
+
+func TestValuePortfolio(t *testing.T) {
+	e := portfolioEvaluation()
+	summary, _, err := valuePortfolio(Alert{Email: "a@b.c"}, e.Holdings["a@b.c"], "USD", e)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 60000.0, summary.Total)
+		assert.Equal(t, 0.0, summary.PnLPercent())
+		assert.Equal(t, 50.0, summary.Positions[0].PnLPercent)
+		assert.Equal(t, -25.0, summary.Positions[1].PnLPercent)
+		assert.Equal(t, 50.0, summary.Positions[0].Allocation)
+	}
+
+	_, _, err = valuePortfolio(Alert{Email: "x@y.z"}, nil, "USD", e)
+	assert.Error(t, err)
+}
+
+func TestPortfolioAlerts(t *testing.T) {
+	e := portfolioEvaluation()
+
+	value := Alert{Kind: ALERT_KIND_PORTFOLIO_VALUE, Email: "a@b.c", Threshold: 55000, Direction: DIRECTION_ABOVE}
+	assert.NoError(t, validateAlert(&value))
+	r, err := evaluateAlert(value, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, 60000.0, r.Notification.CurrentValue)
+		assert.Len(t, r.Notification.Portfolio.Positions, 2)
+	}
+
+	pnl := Alert{Kind: ALERT_KIND_POSITION_PNL, Email: "a@b.c", CoinID: "ethereum", CoinSymbol: "ETH", ThresholdDelta: -20}
+	assert.NoError(t, validateAlert(&pnl))
+	r, err = evaluateAlert(pnl, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, "ETH", r.Notification.CoinSymbol)
+		assert.Equal(t, -25.0, r.Notification.CurrentDelta)
+	}
+
+	drift := Alert{Kind: ALERT_KIND_ALLOCATION_DRIFT, Email: "a@b.c", Threshold: 5}
+	assert.NoError(t, validateAlert(&drift))
+	r, err = evaluateAlert(drift, e)
+	if assert.NoError(t, err) {
+		assert.False(t, r.Violation, "allocations are exactly on target")
+	}
+	e.CoinDeltas["BTC_BITCOIN"] = CoinInfo{ID: "bitcoin", Symbol: "BTC", Name: "Bitcoin", PriceUSD: "90000", LastUpdated: e.CoinDeltas["BTC_BITCOIN"].LastUpdated}
+	r, err = evaluateAlert(drift, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, "BTC 60.0% (target 50%); ETH 40.0% (target 50%)", r.Notification.Detail)
+	}
+}
+
+func TestPortfolioSummaryTable(t *testing.T) {
+	e := portfolioEvaluation()
+	r, _ := evaluateAlert(Alert{Kind: ALERT_KIND_PORTFOLIO_VALUE, Email: "a@b.c", Threshold: 1, Direction: DIRECTION_ABOVE,
+		PriceCurrency: "USD"}, e)
+	body := prettyPrintNotifications([]string{"portfolio"}, []Notification{r.Notification})
+	assert.Contains(t, body, "<h3>Portfolio Summary</h3>")
+	assert.Contains(t, body, "<tr><td>ETH</td><td>10</td><td>30000.00 USD</td><td>-25.0%</td><td>50.0%</td></tr>")
+	assert.Contains(t, body, "<td><b>60000.00 USD</b></td>")
+}
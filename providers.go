@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/levigross/grequests"
+)
+
+// PriceProvider is a source of ticker data. Each adapter normalizes its
+// upstream format into CoinInfo values keyed by createCoinKey.
+type PriceProvider interface {
+	Name() string
+	FetchPrices() (map[string]CoinInfo, error)
+}
+
+// ProviderConfig describes a single configured ticker source.
+type ProviderConfig struct {
+	Name  string            `json:"name"`
+	Kind  string            `json:"kind"`
+	URL   string            `json:"url"`
+	Quote string            `json:"quote"`
+	Names map[string]string `json:"names"` // symbol -> coin name, for feeds that only carry symbols.
+}
+
+type providerFactory func(config ProviderConfig) PriceProvider
+
+var providerRegistry = make(map[string]providerFactory)
+
+// Active providers, loaded from configuration at startup.
+var priceProviders []PriceProvider
+
+const PROVIDERS_CONFIG_ENV = "PRICE_PROVIDERS_CONFIG"
+
+func init() {
+	registerProvider("coinmarketcap", func(config ProviderConfig) PriceProvider {
+		return &coinMarketCapProvider{config}
+	})
+	registerProvider("coingecko", func(config ProviderConfig) PriceProvider {
+		return &coinGeckoProvider{config}
+	})
+	registerProvider("exchange", func(config ProviderConfig) PriceProvider {
+		return &exchangeTickerProvider{config}
+	})
+	registerProvider("file", func(config ProviderConfig) PriceProvider {
+		return &fileProvider{config}
+	})
+}
+
+func registerProvider(kind string, factory providerFactory) {
+	providerRegistry[kind] = factory
+}
+
+func newPriceProvider(config ProviderConfig) (PriceProvider, error) {
+	factory, ok := providerRegistry[config.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown price provider kind: %s", config.Kind)
+	}
+	if config.Name == "" {
+		config.Name = config.Kind
+	}
+	return factory(config), nil
+}
+
+func defaultProviderConfigs() []ProviderConfig {
+	return []ProviderConfig{{Name: "coinmarketcap", Kind: "coinmarketcap", URL: COIN_API}}
+}
+
+// loadProviderConfigs reads the provider list from the JSON file at path,
+// falling back to the coinmarketcap ticker when no path is given.
+func loadProviderConfigs(path string) ([]ProviderConfig, error) {
+	if path == "" {
+		return defaultProviderConfigs(), nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []ProviderConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return nil, errors.New("no price providers configured in " + path)
+	}
+	return configs, nil
+}
+
+func configurePriceProviders() error {
+	configs, err := loadProviderConfigs(os.Getenv(PROVIDERS_CONFIG_ENV))
+	if err != nil {
+		return err
+	}
+	var providers []PriceProvider
+	for _, config := range configs {
+		provider, err := newPriceProvider(config)
+		if err != nil {
+			return err
+		}
+		providers = append(providers, newGuardedProvider(provider))
+	}
+	priceProviders = providers
+	log.Debugf("configured %d price providers", len(priceProviders))
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func coinInfoMap(coinInfos []CoinInfo) map[string]CoinInfo {
+	var coinMap = make(map[string]CoinInfo)
+	for _, coinInfo := range coinInfos {
+		coinMap[createCoinKey(coinInfo.Symbol, coinInfo.Name)] = coinInfo
+	}
+	return coinMap
+}
+
+// fillPriceBTC derives PriceBTC from the BTC quote for feeds that only report USD.
+func fillPriceBTC(coinInfos []CoinInfo) {
+	var btcUSD float64
+	for _, coinInfo := range coinInfos {
+		if strings.ToUpper(coinInfo.Symbol) == "BTC" {
+			btcUSD, _ = strconv.ParseFloat(coinInfo.PriceUSD, 64)
+			break
+		}
+	}
+	if btcUSD <= 0 {
+		return
+	}
+	for i := range coinInfos {
+		if coinInfos[i].PriceBTC != "" {
+			continue
+		}
+		priceUSD, err := strconv.ParseFloat(coinInfos[i].PriceUSD, 64)
+		if err == nil {
+			coinInfos[i].PriceBTC = formatFloat(priceUSD / btcUSD)
+		}
+	}
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := grequests.Get(url, &grequests.RequestOptions{RequestTimeout: fetchPolicy.Timeout})
+	if err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.Unmarshal(resp.Bytes(), v)
+}
+
+// coinMarketCapProvider reads the coinmarketcap v1 ticker format, which
+// CoinInfo mirrors directly.
+type coinMarketCapProvider struct {
+	config ProviderConfig
+}
+
+func (p *coinMarketCapProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *coinMarketCapProvider) FetchPrices() (map[string]CoinInfo, error) {
+	var coinInfos []CoinInfo
+	if err := getJSON(p.config.URL, &coinInfos); err != nil {
+		return nil, err
+	}
+	return coinInfoMap(coinInfos), nil
+}
+
+// coinGeckoProvider reads the /coins/markets format used by CoinGecko-style APIs.
+type coinGeckoProvider struct {
+	config ProviderConfig
+}
+
+type coinGeckoMarket struct {
+	ID          string  `json:"id"`
+	Symbol      string  `json:"symbol"`
+	Name        string  `json:"name"`
+	Price       float64 `json:"current_price"`
+	MarketCap   float64 `json:"market_cap"`
+	Rank        int     `json:"market_cap_rank"`
+	Volume      float64 `json:"total_volume"`
+	Supply      float64 `json:"circulating_supply"`
+	TotalSupply float64 `json:"total_supply"`
+	Change1h    float64 `json:"price_change_percentage_1h_in_currency"`
+	Change24h   float64 `json:"price_change_percentage_24h_in_currency"`
+	Change7d    float64 `json:"price_change_percentage_7d_in_currency"`
+	LastUpdated string  `json:"last_updated"`
+}
+
+func (p *coinGeckoProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *coinGeckoProvider) FetchPrices() (map[string]CoinInfo, error) {
+	var markets []coinGeckoMarket
+	if err := getJSON(p.config.URL, &markets); err != nil {
+		return nil, err
+	}
+	var coinInfos []CoinInfo
+	for _, m := range markets {
+		var lastUpdated string
+		if t, err := time.Parse(time.RFC3339, m.LastUpdated); err == nil {
+			lastUpdated = strconv.FormatInt(t.Unix(), 10)
+		}
+		coinInfos = append(coinInfos, CoinInfo{
+			ID: m.ID, Name: m.Name, Symbol: strings.ToUpper(m.Symbol), Rank: strconv.Itoa(m.Rank),
+			PriceUSD: formatFloat(m.Price), Volume24: formatFloat(m.Volume), MarketCapUSD: formatFloat(m.MarketCap),
+			Supply: formatFloat(m.Supply), TotalSupply: formatFloat(m.TotalSupply),
+			Change1h: formatFloat(m.Change1h), Change24h: formatFloat(m.Change24h), Change7d: formatFloat(m.Change7d),
+			LastUpdated: lastUpdated,
+		})
+	}
+	fillPriceBTC(coinInfos)
+	return coinInfoMap(coinInfos), nil
+}
+
+// exchangeTickerProvider reads a 24h exchange ticker list (Binance-style),
+// keeping only pairs quoted in config.Quote. Exchange tickers carry no coin
+// names, so config.Names maps base symbols to the names used by createCoinKey.
+type exchangeTickerProvider struct {
+	config ProviderConfig
+}
+
+type exchangeTicker struct {
+	Symbol             string `json:"symbol"`
+	LastPrice          string `json:"lastPrice"`
+	PriceChangePercent string `json:"priceChangePercent"`
+	QuoteVolume        string `json:"quoteVolume"`
+	CloseTime          int64  `json:"closeTime"`
+}
+
+func (p *exchangeTickerProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *exchangeTickerProvider) FetchPrices() (map[string]CoinInfo, error) {
+	var tickers []exchangeTicker
+	if err := getJSON(p.config.URL, &tickers); err != nil {
+		return nil, err
+	}
+	quote := strings.ToUpper(p.config.Quote)
+	if quote == "" {
+		quote = "USDT"
+	}
+	var coinInfos []CoinInfo
+	for _, t := range tickers {
+		pair := strings.ToUpper(t.Symbol)
+		if !strings.HasSuffix(pair, quote) || len(pair) == len(quote) {
+			continue
+		}
+		symbol := strings.TrimSuffix(pair, quote)
+		name, ok := p.config.Names[symbol]
+		if !ok {
+			name = symbol
+		}
+		coinInfos = append(coinInfos, CoinInfo{
+			ID: strings.ToLower(name), Name: name, Symbol: symbol,
+			PriceUSD: t.LastPrice, Volume24: t.QuoteVolume, Change24h: t.PriceChangePercent,
+			LastUpdated: strconv.FormatInt(t.CloseTime/1000, 10),
+		})
+	}
+	fillPriceBTC(coinInfos)
+	return coinInfoMap(coinInfos), nil
+}
+
+// fileProvider reads a local JSON file in the coinmarketcap v1 format.
+type fileProvider struct {
+	config ProviderConfig
+}
+
+func (p *fileProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *fileProvider) FetchPrices() (map[string]CoinInfo, error) {
+	b, err := ioutil.ReadFile(p.config.URL)
+	if err != nil {
+		return nil, err
+	}
+	var coinInfos []CoinInfo
+	if err := json.Unmarshal(b, &coinInfos); err != nil {
+		return nil, err
+	}
+	return coinInfoMap(coinInfos), nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	coinMarketCapFixture = `[{"id":"bitcoin","name":"Bitcoin","symbol":"BTC","rank":"1","price_usd":"2500.5","price_btc":"1.0","percent_change_1h":"0.5","percent_change_24h":"-2.1","percent_change_7d":"8.0","last_updated":"1500965432"}]`
+	coinGeckoFixture     = `[{"id":"bitcoin","symbol":"btc","name":"Bitcoin","current_price":60000,"market_cap":1200000000000,"market_cap_rank":1,"total_volume":30000000000,"price_change_percentage_1h_in_currency":0.1,"price_change_percentage_24h_in_currency":-1.5,"price_change_percentage_7d_in_currency":4.2,"last_updated":"2024-03-01T12:00:00.000Z"},{"id":"ethereum","symbol":"eth","name":"Ethereum","current_price":3000,"market_cap_rank":2,"last_updated":"2024-03-01T12:00:00.000Z"}]`
+	exchangeFixture      = `[{"symbol":"BTCUSDT","lastPrice":"60000.00","priceChangePercent":"-1.50","quoteVolume":"1000000","closeTime":1709294400000},{"symbol":"ETHBTC","lastPrice":"0.05"}]`
+)
+
+func stubServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+}
+
+// fixtureServer serves a provider response recorded under testdata.
+func fixtureServer(t *testing.T, file string) *httptest.Server {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stubServer(string(body))
+}
+
+func TestCoinMarketCapProvider(t *testing.T) {
+	server := stubServer(coinMarketCapFixture)
+	defer server.Close()
+
+	p, err := newPriceProvider(ProviderConfig{Kind: "coinmarketcap", URL: server.URL})
+	assert.NoError(t, err)
+	prices, err := p.FetchPrices()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2500.5", prices["BTC_BITCOIN"].PriceUSD)
+		assert.Equal(t, "-2.1", prices["BTC_BITCOIN"].Change24h)
+	}
+}
+
+func TestCoinGeckoProvider(t *testing.T) {
+	server := stubServer(coinGeckoFixture)
+	defer server.Close()
+
+	p, _ := newPriceProvider(ProviderConfig{Kind: "coingecko", URL: server.URL})
+	prices, err := p.FetchPrices()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "BTC", prices["BTC_BITCOIN"].Symbol)
+		assert.Equal(t, "60000", prices["BTC_BITCOIN"].PriceUSD)
+		assert.Equal(t, "-1.5", prices["BTC_BITCOIN"].Change24h)
+		assert.Equal(t, "1709294400", prices["BTC_BITCOIN"].LastUpdated)
+		assert.Equal(t, "0.05", prices["ETH_ETHEREUM"].PriceBTC)
+	}
+}
+
+func TestExchangeTickerProvider(t *testing.T) {
+	server := stubServer(exchangeFixture)
+	defer server.Close()
+
+	p, _ := newPriceProvider(ProviderConfig{Kind: "exchange", URL: server.URL, Names: map[string]string{"BTC": "Bitcoin"}})
+	prices, err := p.FetchPrices()
+	if assert.NoError(t, err) {
+		assert.Len(t, prices, 1)
+		assert.Equal(t, "60000.00", prices["BTC_BITCOIN"].PriceUSD)
+		assert.Equal(t, "1709294400", prices["BTC_BITCOIN"].LastUpdated)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	f, err := ioutil.TempFile("", "ticker")
+	check(err)
+	defer os.Remove(f.Name())
+	f.WriteString(coinMarketCapFixture)
+	f.Close()
+
+	p, _ := newPriceProvider(ProviderConfig{Kind: "file", URL: f.Name()})
+	prices, err := p.FetchPrices()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2500.5", prices["BTC_BITCOIN"].PriceUSD)
+	}
+}
+
+func TestUnknownProviderKind(t *testing.T) {
+	_, err := newPriceProvider(ProviderConfig{Kind: "nope"})
+	assert.Error(t, err)
+}
+
+func TestGetCurrencyPricesFailover(t *testing.T) {
+	server := stubServer(coinMarketCapFixture)
+	defer server.Close()
+
+	dead, _ := newPriceProvider(ProviderConfig{Kind: "file", URL: "/does/not/exist.json"})
+	live, _ := newPriceProvider(ProviderConfig{Kind: "coinmarketcap", URL: server.URL})
+	priceProviders = []PriceProvider{dead, live}
+	defer func() { priceProviders = nil }()
+
+	prices, failures, err := getCurrencyPrices()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2500.5", prices["BTC_BITCOIN"].PriceUSD)
+		assert.Contains(t, failures, "file")
+	}
+}
+
+func TestRecordedProviderResponses(t *testing.T) {
+	names := map[string]string{"BTC": "Bitcoin", "ETH": "Ethereum"}
+	cases := []struct {
+		kind    string
+		fixture string
+		btc     string
+		eth     string
+	}{
+		{"coinmarketcap", "coinmarketcap_ticker.json", "61250.4", "3380.12"},
+		{"coingecko", "coingecko_markets.json", "61240", "3378.5"},
+		{"exchange", "exchange_a_tickers.json", "61255.10", "3381.00"},
+		{"exchange", "exchange_b_tickers.json", "61870.00", "3382.40"},
+	}
+	for _, c := range cases {
+		server := fixtureServer(t, c.fixture)
+		p, _ := newPriceProvider(ProviderConfig{Kind: c.kind, URL: server.URL, Names: names})
+		prices, err := p.FetchPrices()
+		server.Close()
+		if !assert.NoError(t, err, c.fixture) {
+			continue
+		}
+		assert.Len(t, prices, 2, c.fixture)
+		assert.Equal(t, c.btc, prices["BTC_BITCOIN"].PriceUSD, c.fixture)
+		assert.Equal(t, c.eth, prices["ETH_ETHEREUM"].PriceUSD, c.fixture)
+		assert.Equal(t, "1709294400", prices["BTC_BITCOIN"].LastUpdated, c.fixture)
+		assert.NotEmpty(t, prices["ETH_ETHEREUM"].PriceBTC, c.fixture)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 func getAlerts(c echo.Context) error {
@@ -20,6 +21,14 @@ func getAlerts(c echo.Context) error {
 	return c.String(http.StatusOK, string(res))
 }
 
+func getCoins(c echo.Context) error {
+	coins, err := searchCoins(c.QueryParam("q"))
+	if (err != nil) {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, coins)
+}
+
 func getNotifications(c echo.Context) error {
 	email := c.Param("email")
 	var notifications []Notification
@@ -68,6 +77,22 @@ func addAlert(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, err)
 	}
 
+	if (alert.PriceCurrency == "" && quoteCurrencyKinds[alert.Kind]) {
+		alert.PriceCurrency = userCurrency(alert.Email)
+	}
+	if err := validateAlert(alert); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := validateCooldown(alert, userPlan(alert.Email)); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := resolveAlertCoins(alert); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := validateChannels(alert); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
 	email := alert.Email
 	var count int64
 	db.Table("alerts").Where("email = ? and deleted_at is null", email).Count(&count)
@@ -83,3 +108,206 @@ func addAlert(c echo.Context) error {
 
 
 
+
+// UserSettings are the account settings users change themselves.
+type UserSettings struct {
+	Email    string `json:"email"`
+	Currency string `json:"currency"`
+}
+
+func getUserSettings(c echo.Context) error {
+	email := c.Param("email")
+	return c.JSON(http.StatusOK, UserSettings{Email: email, Currency: userCurrency(email)})
+}
+
+func updateUserSettings(c echo.Context) error {
+	settings := new(UserSettings)
+	if err := c.Bind(settings); err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	if (settings.Email == "") {
+		return c.JSON(http.StatusBadRequest, "missing email")
+	}
+	if err := validateQuoteCurrency(&settings.Currency, false); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := saveUserCurrency(settings.Email, settings.Currency); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+func getHoldings(c echo.Context) error {
+	email := c.Param("email")
+	var holdings []Holding
+	db.Where("email = ?", email).Order("id").Find(&holdings)
+	return c.JSON(http.StatusOK, holdings)
+}
+
+func addHolding(c echo.Context) error {
+	holding := new(Holding)
+	if err := c.Bind(holding); err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	holding.ID = 0
+	if err := validateHolding(holding); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	db.Create(holding)
+	return c.JSON(http.StatusOK, holding)
+}
+
+func updateHolding(c echo.Context) error {
+	var existing Holding
+	if err := db.First(&existing, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, "holding not found")
+	}
+	holding := new(Holding)
+	if err := c.Bind(holding); err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	if (holding.Email != existing.Email) {
+		return c.JSON(http.StatusBadRequest, "email does not match the holding")
+	}
+	holding.Model = existing.Model
+	if err := validateHolding(holding); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	db.Save(holding)
+	return c.JSON(http.StatusOK, holding)
+}
+
+func deleteHolding(c echo.Context) error {
+	holding := new(Holding)
+	if err := c.Bind(holding); err != nil {
+		return err
+	}
+	log.Debugf("Deleting holding id: %d", holding.ID)
+	err := db.Where("id = ? AND email = ?", holding.ID, holding.Email).Delete(&Holding{}).Error
+	if (err != nil) {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+	return c.JSON(http.StatusOK, holding)
+}
+
+func addWebhook(c echo.Context) error {
+	hook := new(Webhook)
+	if err := c.Bind(hook); err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	hook.ID = 0
+	if err := validateWebhook(hook); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	db.Create(hook)
+	return c.JSON(http.StatusOK, hook)
+}
+
+func deleteWebhook(c echo.Context) error {
+	hook := new(Webhook)
+	if err := c.Bind(hook); err != nil {
+		return err
+	}
+	log.Debugf("Deleting webhook id: %d", hook.ID)
+	err := db.Where("id = ? AND email = ?", hook.ID, hook.Email).Delete(&Webhook{}).Error
+	if (err != nil) {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+	return c.JSON(http.StatusOK, hook)
+}
+
+// getWebhookDeliveries lists the latest delivery attempts to a webhook, e.g.
+// /api/webhooks/3/deliveries?email=user@example.com
+func getWebhookDeliveries(c echo.Context) error {
+	var hook Webhook
+	err := db.Where("id = ? AND email = ?", c.Param("id"), c.QueryParam("email")).First(&hook).Error
+	if (err != nil) {
+		return c.JSON(http.StatusNotFound, "webhook not found")
+	}
+	deliveries, err := webhookDeliveries(hook.ID)
+	if (err != nil) {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+// addChatIntegration connects a chat for a user, replacing their existing
+// integration of the same kind.
+func addChatIntegration(c echo.Context) error {
+	integration := new(ChatIntegration)
+	if err := c.Bind(integration); err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	integration.ID = 0
+	if err := validateChatIntegration(integration); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	db.Unscoped().Where("email = ? AND kind = ?", integration.Email, integration.Kind).Delete(&ChatIntegration{})
+	db.Create(integration)
+	return c.JSON(http.StatusOK, integration)
+}
+
+func deleteChatIntegration(c echo.Context) error {
+	integration := new(ChatIntegration)
+	if err := c.Bind(integration); err != nil {
+		return err
+	}
+	log.Debugf("Deleting %s integration for %s", integration.Kind, integration.Email)
+	err := db.Unscoped().Where("email = ? AND kind = ?", integration.Email, integration.Kind).Delete(&ChatIntegration{}).Error
+	if (err != nil) {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+	return c.JSON(http.StatusOK, integration)
+}
+
+func getChatIntegrations(c echo.Context) error {
+	email := c.Param("email")
+	var integrations []ChatIntegration
+	db.Where("email = ?", email).Order("id").Find(&integrations)
+	return c.JSON(http.StatusOK, integrations)
+}
+
+// PhoneVerification is the body of the phone routes.
+type PhoneVerification struct {
+	Email  string `json:"email"`
+	Number string `json:"number"`
+	Code   string `json:"code"`
+}
+
+// addPhoneNumber sets a user's phone number and texts them a verification code.
+func addPhoneNumber(c echo.Context) error {
+	req := new(PhoneVerification)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	phone, err := requestPhoneVerification(req.Email, req.Number, time.Now())
+	if (err != nil) {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, phone)
+}
+
+func verifyPhoneNumber(c echo.Context) error {
+	req := new(PhoneVerification)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	phone, err := userPhoneNumber(req.Email)
+	if (err != nil) {
+		return c.JSON(http.StatusNotFound, "phone number not found")
+	}
+	err = checkPhoneVerification(&phone, req.Code, time.Now())
+	db.Save(&phone)
+	if (err != nil) {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, phone)
+}
+
+func getPhoneNumber(c echo.Context) error {
+	phone, err := userPhoneNumber(c.Param("email"))
+	if (err != nil) {
+		return c.JSON(http.StatusNotFound, "phone number not found")
+	}
+	return c.JSON(http.StatusOK, phone)
+}
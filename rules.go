@@ -0,0 +1,292 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const ALERT_KIND_COMPOSITE = "composite"
+
+// Composite rule limits, to keep evaluation cheap and emails readable.
+const (
+	MAX_RULE_CONDITIONS = 20
+	MAX_RULE_DEPTH      = 4
+)
+
+// Rule is a composite alert expression. A rule is either a group combining
+// child rules with "and"/"or", or a single condition comparing a metric of
+// one coin against a value, e.g. {"coin": "BTC", "metric": "change_24h",
+// "cmp": "<", "value": -5}. Rules are stored as JSON on the alert.
+type Rule struct {
+	Op    string `json:"op,omitempty"`
+	Rules []Rule `json:"rules,omitempty"`
+
+	Coin   string  `json:"coin,omitempty"` // symbol ("BTC") or coin key ("BTC_BITCOIN").
+	Metric string  `json:"metric,omitempty"`
+	Cmp    string  `json:"cmp,omitempty"`
+	Target float64 `json:"value"`
+}
+
+// Fixed rule metrics. Percent changes are written change_<window>, e.g.
+// change_24h or change_4h, with any window accepted by parseTimeDelta.
+var ruleMetrics = map[string]func(CoinInfo) string{
+	"price_usd":      func(c CoinInfo) string { return c.PriceUSD },
+	"price_btc":      func(c CoinInfo) string { return c.PriceBTC },
+	"volume_24h":     func(c CoinInfo) string { return c.Volume24 },
+	"market_cap_usd": func(c CoinInfo) string { return c.MarketCapUSD },
+	"rank":           func(c CoinInfo) string { return c.Rank },
+}
+
+const CHANGE_METRIC_PREFIX = "change_"
+
+var ruleComparisons = map[string]bool{">": true, ">=": true, "<": true, "<=": true}
+
+func init() {
+	registerAlertKind(ALERT_KIND_COMPOSITE, alertKind{validateCompositeAlert, evaluateCompositeAlert})
+}
+
+func (r Rule) Value() (driver.Value, error) {
+	b, err := json.Marshal(r)
+	return string(b), err
+}
+
+func (r *Rule) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return fmt.Errorf("cannot scan %T into Rule", src)
+}
+
+func (r Rule) isGroup() bool {
+	return r.Op != ""
+}
+
+func (r Rule) String() string {
+	if !r.isGroup() {
+		return fmt.Sprintf("%s %s %s %s", r.Coin, r.Metric, r.Cmp, formatFloat(r.Target))
+	}
+	var parts []string
+	for _, child := range r.Rules {
+		s := child.String()
+		if child.isGroup() {
+			s = "(" + s + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " "+strings.ToUpper(r.Op)+" ")
+}
+
+func compare(value float64, cmp string, target float64) bool {
+	switch cmp {
+	case ">":
+		return value > target
+	case ">=":
+		return value >= target
+	case "<":
+		return value < target
+	case "<=":
+		return value <= target
+	}
+	return false
+}
+
+// resolveRuleCoin maps a rule's coin reference to a key in coins, accepting
+// either an exact coin key or a symbol that matches exactly one coin.
+func resolveRuleCoin(coin string, coins map[string]CoinInfo) (string, error) {
+	coin = strings.ToUpper(coin)
+	if _, ok := coins[coin]; ok {
+		return coin, nil
+	}
+	var matches []string
+	for key, coinInfo := range coins {
+		if strings.ToUpper(coinInfo.Symbol) == coin {
+			matches = append(matches, key)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("unknown coin %q", coin)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("coin %q is ambiguous, use one of: %s", coin, strings.Join(matches, ", "))
+}
+
+func validateRuleMetric(metric string) error {
+	if _, ok := ruleMetrics[metric]; ok {
+		return nil
+	}
+	if strings.HasPrefix(metric, CHANGE_METRIC_PREFIX) {
+		if _, err := parseTimeDelta(strings.TrimPrefix(metric, CHANGE_METRIC_PREFIX)); err != nil {
+			return fmt.Errorf("unknown metric %q: %s", metric, err.Error())
+		}
+		return nil
+	}
+	var names []string
+	for name := range ruleMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown metric %q: expected one of %s or change_<window>", metric, strings.Join(names, ", "))
+}
+
+// validateRule checks the structure of a rule and that every condition
+// refers to one of coins.
+func validateRule(r Rule, coins map[string]CoinInfo, depth int, conditions *int) error {
+	if depth > MAX_RULE_DEPTH {
+		return fmt.Errorf("rule is nested more than %d levels deep", MAX_RULE_DEPTH)
+	}
+	if r.isGroup() {
+		op := strings.ToLower(r.Op)
+		if op != "and" && op != "or" {
+			return fmt.Errorf("unknown rule op %q: expected \"and\" or \"or\"", r.Op)
+		}
+		if len(r.Rules) < 2 {
+			return fmt.Errorf("%q group needs at least two rules", op)
+		}
+		for _, child := range r.Rules {
+			if err := validateRule(child, coins, depth+1, conditions); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	*conditions++
+	if *conditions > MAX_RULE_CONDITIONS {
+		return fmt.Errorf("rule has more than %d conditions", MAX_RULE_CONDITIONS)
+	}
+	if r.Coin == "" {
+		return errors.New("rule condition is missing a coin")
+	}
+	if err := validateRuleMetric(r.Metric); err != nil {
+		return err
+	}
+	if !ruleComparisons[r.Cmp] {
+		return fmt.Errorf("unknown comparison %q: expected >, >=, < or <=", r.Cmp)
+	}
+	if _, err := resolveRuleCoin(r.Coin, coins); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateCompositeAlert(alert *Alert) error {
+	if alert.Rule == nil {
+		return errors.New("composite alerts need a rule")
+	}
+	coins, err := catalogCoinInfos()
+	if err != nil {
+		return fmt.Errorf("could not load the coin catalog: %s", err.Error())
+	}
+	conditions := 0
+	return validateRule(*alert.Rule, coins, 1, &conditions)
+}
+
+// ruleResult is the outcome of evaluating a rule, with the observed value of
+// each condition for the notification.
+type ruleResult struct {
+	Observed    []string
+	Coins       []string
+	LastUpdated int64
+}
+
+// evaluateRule reports whether a rule matches. A condition that cannot be
+// evaluated, e.g. on a stale quote, only fails its group when it could change
+// the outcome: an "or" with a matching branch still matches, and an "and"
+// with a false branch is still false.
+func evaluateRule(r Rule, e evaluation, result *ruleResult) (bool, error) {
+	if r.isGroup() {
+		and := strings.ToLower(r.Op) == "and"
+		// Every branch is evaluated so the notification shows all observed values.
+		decided := false
+		var firstErr error
+		for _, child := range r.Rules {
+			m, err := evaluateRule(child, e, result)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			// A true branch decides an "or", a false branch an "and".
+			decided = decided || m != and
+		}
+		if decided {
+			return !and, nil
+		}
+		return and, firstErr
+	}
+
+	key, err := resolveRuleCoin(r.Coin, e.CoinDeltas)
+	if err != nil {
+		return false, errStaleQuote
+	}
+	coinInfo := e.CoinDeltas[key]
+	if isStaleQuote(coinInfo, fetchPolicy.MaxQuoteAge, e.Now) {
+		return false, errStaleQuote
+	}
+
+	var value float64
+	if field, ok := ruleMetrics[r.Metric]; ok {
+		value, err = strconv.ParseFloat(field(coinInfo), 64)
+	} else {
+		value, err = coinChange(key, coinInfo, strings.TrimPrefix(r.Metric, CHANGE_METRIC_PREFIX), e.Now)
+	}
+	if err != nil {
+		return false, fmt.Errorf("no %s for %s: %s", r.Metric, key, err.Error())
+	}
+
+	matched := compare(value, r.Cmp, r.Target)
+	result.Observed = append(result.Observed, fmt.Sprintf("%s %s = %s (%s %s)",
+		coinInfo.Symbol, r.Metric, formatFloat(value), r.Cmp, formatFloat(r.Target)))
+	result.Coins = append(result.Coins, coinInfo.Symbol)
+	if lastUpdated, err := strconv.ParseInt(coinInfo.LastUpdated, 10, 64); err == nil && lastUpdated > result.LastUpdated {
+		result.LastUpdated = lastUpdated
+	}
+	return matched, nil
+}
+
+func evaluateCompositeAlert(alert Alert, e evaluation) (reading, error) {
+	if alert.Rule == nil {
+		return reading{}, errors.New("composite alert has no rule")
+	}
+	var result ruleResult
+	matched, err := evaluateRule(*alert.Rule, e, &result)
+	if err != nil {
+		return reading{}, err
+	}
+
+	r := reading{Violation: matched, Rearmed: !matched}
+	if matched {
+		r.Value = 1
+		log.Debugf("Violation composite alert ID(%d): %s", alert.ID, alert.Rule.String())
+	}
+	r.Notification = Notification{
+		CoinSymbol: strings.Join(uniqueStrings(result.Coins), ", "), LastUpdated: result.LastUpdated,
+		Metric: alert.Rule.String(), Detail: strings.Join(result.Observed, "; "),
+	}
+	return r, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
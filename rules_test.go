@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseRule(t *testing.T, s string) Rule {
+	var r Rule
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestValidateRule(t *testing.T) {
+	coins := coinInfoMap([]CoinInfo{{Symbol: "BTC", Name: "Bitcoin"}, {Symbol: "ETH", Name: "Ethereum"},
+		{Symbol: "BTG", Name: "Bitcoin Gold"}, {Symbol: "BTG", Name: "Bitgem"}})
+
+	valid := parseRule(t, `{"op": "and", "rules": [
+		{"coin": "BTC", "metric": "change_24h", "cmp": "<", "value": -5},
+		{"coin": "eth_ethereum", "metric": "change_4h", "cmp": "<", "value": -5}]}`)
+	conditions := 0
+	assert.NoError(t, validateRule(valid, coins, 1, &conditions))
+
+	cases := map[string]string{
+		`{"coin": "DOGE", "metric": "price_usd", "cmp": ">", "value": 1}`:                         `unknown coin "DOGE"`,
+		`{"coin": "BTG", "metric": "price_usd", "cmp": ">", "value": 1}`:                          `coin "BTG" is ambiguous`,
+		`{"coin": "BTC", "metric": "sentiment", "cmp": ">", "value": 1}`:                          `unknown metric "sentiment"`,
+		`{"coin": "BTC", "metric": "change_1y", "cmp": ">", "value": 1}`:                          `unknown metric "change_1y"`,
+		`{"coin": "BTC", "metric": "price_usd", "cmp": "==", "value": 1}`:                         `unknown comparison "=="`,
+		`{"op": "xor", "rules": [{"coin": "BTC"}, {"coin": "ETH"}]}`:                              `unknown rule op "xor"`,
+		`{"op": "or", "rules": [{"coin": "BTC", "metric": "price_usd", "cmp": ">", "value": 1}]}`: `needs at least two rules`,
+	}
+	for rule, message := range cases {
+		conditions := 0
+		err := validateRule(parseRule(t, rule), coins, 1, &conditions)
+		if assert.Error(t, err, rule) {
+			assert.Contains(t, err.Error(), message)
+		}
+	}
+}
+
+func TestEvaluateCompositeAlert(t *testing.T) {
+	rule := parseRule(t, `{"op": "or", "rules": [
+		{"coin": "SOL", "metric": "price_usd", "cmp": ">", "value": 200},
+		{"op": "and", "rules": [
+			{"coin": "BTC", "metric": "change_24h", "cmp": "<", "value": -5},
+			{"coin": "ETH", "metric": "change_24h", "cmp": "<", "value": -5}]}]}`)
+	alert := Alert{Kind: ALERT_KIND_COMPOSITE, Rule: &rule}
+	assert.Equal(t, "SOL price_usd > 200 OR (BTC change_24h < -5 AND ETH change_24h < -5)", rule.String())
+
+	e := testEvaluation(CoinInfo{Symbol: "SOL", Name: "Solana", PriceUSD: "150"},
+		CoinInfo{Symbol: "BTC", Name: "Bitcoin", PriceUSD: "1", Change24h: "-6"},
+		CoinInfo{Symbol: "ETH", Name: "Ethereum", PriceUSD: "1", Change24h: "-4"})
+	r, err := evaluateAlert(alert, e)
+	assert.NoError(t, err)
+	assert.False(t, r.Violation)
+
+	e.CoinDeltas["ETH_ETHEREUM"] = CoinInfo{Symbol: "ETH", Name: "Ethereum", PriceUSD: "1", Change24h: "-7",
+		LastUpdated: e.CoinDeltas["BTC_BITCOIN"].LastUpdated}
+	r, err = evaluateAlert(alert, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, "SOL, BTC, ETH", r.Notification.CoinSymbol)
+		assert.Contains(t, r.Notification.Detail, "ETH change_24h = -7 (< -5)")
+	}
+
+	// A missing coin only matters when the other branches cannot decide.
+	delete(e.CoinDeltas, "SOL_SOLANA")
+	r, err = evaluateAlert(alert, e)
+	if assert.NoError(t, err) && assert.True(t, r.Violation) {
+		assert.Equal(t, "BTC, ETH", r.Notification.CoinSymbol)
+	}
+	e.CoinDeltas["ETH_ETHEREUM"] = CoinInfo{Symbol: "ETH", Name: "Ethereum", PriceUSD: "1", Change24h: "-4",
+		LastUpdated: e.CoinDeltas["BTC_BITCOIN"].LastUpdated}
+	_, err = evaluateAlert(alert, e)
+	assert.Equal(t, errStaleQuote, err)
+
+	and := parseRule(t, `{"op": "and", "rules": [
+		{"coin": "SOL", "metric": "price_usd", "cmp": ">", "value": 200},
+		{"coin": "ETH", "metric": "change_24h", "cmp": "<", "value": -5}]}`)
+	r, err = evaluateAlert(Alert{Kind: ALERT_KIND_COMPOSITE, Rule: &and}, e)
+	if assert.NoError(t, err) {
+		assert.False(t, r.Violation)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/levigross/grequests"
+)
+
+const TWILIO_API = "https://api.twilio.com"
+
+// SMS rendering limits. Each notification gets one line; a message holds a
+// few lines so it stays within a handful of segments.
+const (
+	SMS_PREFIX    = "CryptoAlarms: "
+	SMS_LINE_MAX  = 120
+	SMS_MAX_LINES = 4
+)
+
+// Phone verification. Codes expire and lock after too many wrong guesses,
+// and a new code can only be requested once per resend interval.
+const (
+	VERIFICATION_CODE_DIGITS  = 6
+	VERIFICATION_CODE_TTL     = 10 * time.Minute
+	VERIFICATION_MAX_ATTEMPTS = 5
+	VERIFICATION_RESEND       = time.Minute
+)
+
+// Limits across users. A number only gets a few verification codes a day,
+// however many accounts ask for them, and all SMS share a daily budget that
+// NotifierConfig.DailyLimit can change.
+const (
+	SMS_NUMBER_DAILY_CODES = 3
+	SMS_DAILY_LIMIT        = 1000
+)
+
+// Purposes recorded on SMSMessage.
+const (
+	SMS_NOTIFICATION = "notification"
+	SMS_VERIFICATION = "verification"
+)
+
+var (
+	errSMSQuota       = errors.New("daily SMS quota reached")
+	errSMSNumberLimit = errors.New("too many verification codes sent to this number today")
+	errSMSDailyLimit  = errors.New("daily SMS limit reached for all users")
+)
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+// SMSSender delivers a text message to a phone number.
+type SMSSender interface {
+	SendSMS(to string, body string) error
+}
+
+// twilioSender sends through the Twilio Messages REST API, or any service
+// that mimics it at config.URL. Username and Password are the account SID
+// and auth token.
+type twilioSender struct {
+	config NotifierConfig
+}
+
+func (s *twilioSender) SendSMS(to string, body string) error {
+	base := s.config.URL
+	if base == "" {
+		base = TWILIO_API
+	}
+	url := strings.TrimSuffix(base, "/") + "/2010-04-01/Accounts/" + s.config.Username + "/Messages.json"
+	resp, err := grequests.Post(url, &grequests.RequestOptions{
+		Data:           map[string]string{"To": to, "From": s.config.From, "Body": body},
+		Auth:           []string{s.config.Username, s.config.Password},
+		RequestTimeout: NOTIFY_TIMEOUT,
+	})
+	if err != nil {
+		if urlErr, ok := err.(*neturl.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	if !resp.Ok {
+		var apiErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if resp.JSON(&apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("twilio: %s (code %d)", apiErr.Message, apiErr.Code)
+		}
+		return fmt.Errorf("twilio: returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// normalizePhoneNumber strips formatting from a number and checks that it is
+// in international E.164 form.
+func normalizePhoneNumber(number string) (string, error) {
+	normalized := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "").Replace(number)
+	if !e164.MatchString(normalized) {
+		return "", fmt.Errorf("phone number %q must be in international format, e.g. +14155550123", number)
+	}
+	return normalized, nil
+}
+
+// truncateRunes shortens s to max runes, marking the cut with "...".
+func truncateRunes(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max-3]) + "..."
+}
+
+// smsLine summarizes a notification in one line with its first two fields,
+// e.g. "BTC over 70k: BTC Current Price 70250.00 USD, Alert Level above 70000.00 USD".
+func smsLine(alertName string, n Notification) string {
+	fields := notificationFields(n)
+	var parts []string
+	for _, f := range fields[:min(2, len(fields))] {
+		parts = append(parts, f.Name+" "+f.Value)
+	}
+	return truncateRunes(fmt.Sprintf("%s: %s %s", alertName, n.CoinSymbol, strings.Join(parts, ", ")), SMS_LINE_MAX)
+}
+
+func smsText(m Message) string {
+	var lines []string
+	for i, n := range m.Notifications {
+		if i == SMS_MAX_LINES {
+			lines = append(lines, fmt.Sprintf("+%d more, see your email or dashboard", len(m.Notifications)-i))
+			break
+		}
+		lines = append(lines, smsLine(m.AlertNames[i], n))
+	}
+	return SMS_PREFIX + strings.Join(lines, "\n")
+}
+
+// startOfDay is the start of t's UTC day, when SMS quotas reset.
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func userPhoneNumber(email string) (PhoneNumber, error) {
+	var phone PhoneNumber
+	err := db.Where("email = ?", email).First(&phone).Error
+	return phone, err
+}
+
+func smsSentSince(email string, since time.Time) (int, error) {
+	var count int
+	err := db.Model(&SMSMessage{}).Where("email = ? AND sent = ? AND created_at >= ?", email, true, since).
+		Count(&count).Error
+	return count, err
+}
+
+func smsSentTo(number string, purpose string, since time.Time) (int, error) {
+	var count int
+	err := db.Model(&SMSMessage{}).Where("number = ? AND purpose = ? AND sent = ? AND created_at >= ?", number, purpose, true, since).
+		Count(&count).Error
+	return count, err
+}
+
+func smsSentTotal(since time.Time) (int, error) {
+	var count int
+	err := db.Model(&SMSMessage{}).Where("sent = ? AND created_at >= ?", true, since).Count(&count).Error
+	return count, err
+}
+
+func recordSMS(m *SMSMessage) error {
+	return db.Create(m).Error
+}
+
+// smsNotifier texts a one-line summary per notification to the user's
+// verified phone number, within their plan's daily quota.
+type smsNotifier struct {
+	config    NotifierConfig
+	sender    SMSSender
+	phone     func(email string) (PhoneNumber, error)
+	quota     func(email string) int
+	sentSince func(email string, since time.Time) (int, error)
+	sentTo    func(number string, purpose string, since time.Time) (int, error)
+	sentTotal func(since time.Time) (int, error)
+	record    func(m *SMSMessage) error
+}
+
+func newSMSNotifier(config NotifierConfig, sender SMSSender) *smsNotifier {
+	return &smsNotifier{config, sender, userPhoneNumber,
+		func(email string) int { return userPlan(email).SMSDailyQuota },
+		smsSentSince, smsSentTo, smsSentTotal, recordSMS}
+}
+
+func (n *smsNotifier) Name() string {
+	return n.config.Name
+}
+
+func (n *smsNotifier) Send(m Message) error {
+	phone, err := n.phone(m.Email)
+	if err != nil || phone.VerifiedAt == nil {
+		return fmt.Errorf("%s has no verified phone number", m.Email)
+	}
+	return n.send(m.Email, phone.Number, SMS_NOTIFICATION, smsText(m), time.Now())
+}
+
+// dailyLimit is the number of SMS all users can send in a day.
+func (n *smsNotifier) dailyLimit() int {
+	if n.config.DailyLimit > 0 {
+		return n.config.DailyLimit
+	}
+	return SMS_DAILY_LIMIT
+}
+
+// checkLimits returns an error if sending to number would exceed the daily
+// limit, the number's verification code limit or the user's quota.
+func (n *smsNotifier) checkLimits(email string, number string, purpose string, now time.Time) error {
+	day := startOfDay(now)
+	total, err := n.sentTotal(day)
+	if err != nil {
+		return err
+	}
+	if total >= n.dailyLimit() {
+		return errSMSDailyLimit
+	}
+	if purpose == SMS_VERIFICATION {
+		codes, err := n.sentTo(number, purpose, day)
+		if err != nil {
+			return err
+		}
+		if codes >= SMS_NUMBER_DAILY_CODES {
+			return errSMSNumberLimit
+		}
+	}
+	sent, err := n.sentSince(email, day)
+	if err != nil {
+		return err
+	}
+	if sent >= n.quota(email) {
+		return errSMSQuota
+	}
+	return nil
+}
+
+// send texts body to number if the limits allow it, recording the attempt.
+func (n *smsNotifier) send(email string, number string, purpose string, body string, now time.Time) error {
+	if err := n.checkLimits(email, number, purpose, now); err != nil {
+		return err
+	}
+	err := n.sender.SendSMS(number, body)
+	record := SMSMessage{Email: email, Number: number, Purpose: purpose, Sent: err == nil}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if recordErr := n.record(&record); recordErr != nil {
+		log.Errorf("could not record SMS to %s: %s", email, recordErr.Error())
+	}
+	return err
+}
+
+// configuredSMSNotifier returns the SMS channel, which also sends
+// verification codes.
+func configuredSMSNotifier() (*smsNotifier, error) {
+	for _, n := range notifiers {
+		if s, ok := n.(*smsNotifier); ok {
+			return s, nil
+		}
+	}
+	return nil, errors.New("SMS is not configured")
+}
+
+func hashVerificationCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func newVerificationCode() string {
+	limit := big.NewInt(1)
+	for i := 0; i < VERIFICATION_CODE_DIGITS; i++ {
+		limit.Mul(limit, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%0*d", VERIFICATION_CODE_DIGITS, n)
+}
+
+// startPhoneVerification sets number as the phone's unverified number with
+// a new code, returning the code to send. The resend interval applies to the
+// user, whichever number they ask for.
+func startPhoneVerification(phone *PhoneNumber, number string, now time.Time) (string, error) {
+	number, err := normalizePhoneNumber(number)
+	if err != nil {
+		return "", err
+	}
+	if phone.CodeSentAt != nil && now.Sub(*phone.CodeSentAt) < VERIFICATION_RESEND {
+		return "", fmt.Errorf("a code was just sent, try again in %s", VERIFICATION_RESEND)
+	}
+	code := newVerificationCode()
+	phone.Number, phone.CodeHash, phone.CodeSentAt, phone.CodeAttempts, phone.VerifiedAt =
+		number, hashVerificationCode(code), &now, 0, nil
+	return code, nil
+}
+
+// checkPhoneVerification checks a code against the pending one, verifying
+// the phone on a match.
+func checkPhoneVerification(phone *PhoneNumber, code string, now time.Time) error {
+	if phone.CodeHash == "" || phone.CodeSentAt == nil {
+		return errors.New("no verification code is pending")
+	}
+	if now.Sub(*phone.CodeSentAt) > VERIFICATION_CODE_TTL {
+		return errors.New("the verification code has expired, request a new one")
+	}
+	if phone.CodeAttempts >= VERIFICATION_MAX_ATTEMPTS {
+		return errors.New("too many attempts, request a new code")
+	}
+	phone.CodeAttempts++
+	hash := hashVerificationCode(strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(phone.CodeHash)) != 1 {
+		return errors.New("wrong verification code")
+	}
+	phone.CodeHash, phone.VerifiedAt = "", &now
+	return nil
+}
+
+// requestPhoneVerification starts verifying number for a user and texts
+// them the code. The code counts against the user's SMS quota.
+func requestPhoneVerification(email string, number string, now time.Time) (PhoneNumber, error) {
+	if email == "" {
+		return PhoneNumber{}, errors.New("missing email")
+	}
+	n, err := configuredSMSNotifier()
+	if err != nil {
+		return PhoneNumber{}, err
+	}
+	phone, err := userPhoneNumber(email)
+	if err != nil {
+		phone = PhoneNumber{Email: email}
+	}
+	code, err := startPhoneVerification(&phone, number, now)
+	if err != nil {
+		return phone, err
+	}
+	body := fmt.Sprintf("%syour verification code is %s. It expires in %d minutes.",
+		SMS_PREFIX, code, int(VERIFICATION_CODE_TTL/time.Minute))
+	if err := n.send(email, phone.Number, SMS_VERIFICATION, body, now); err != nil {
+		return phone, err
+	}
+	return phone, db.Save(&phone).Error
+}
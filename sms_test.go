@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTwilio is a local stand-in for the Twilio Messages API.
+func fakeTwilio(t *testing.T, status int, response string) (*httptest.Server, *[]map[string]string) {
+	var sent []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sid, token, _ := r.BasicAuth()
+		assert.Equal(t, "/2010-04-01/Accounts/AC123/Messages.json", r.URL.Path)
+		assert.Equal(t, "AC123", sid)
+		assert.Equal(t, "secret", token)
+		r.ParseForm()
+		sent = append(sent, map[string]string{"To": r.PostForm.Get("To"), "From": r.PostForm.Get("From"), "Body": r.PostForm.Get("Body")})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	return server, &sent
+}
+
+func twilioConfig(url string) NotifierConfig {
+	return NotifierConfig{Name: "sms", Kind: "sms", URL: url, Username: "AC123", Password: "secret", From: "+15005550006"}
+}
+
+// testSMSNotifier sends through the fake Twilio API to a verified phone,
+// keeping the day's messages in memory.
+func testSMSNotifier(url string, quota int) (*smsNotifier, *[]SMSMessage) {
+	var records []SMSMessage
+	n, _ := newNotifier(twilioConfig(url))
+	s := n.(*smsNotifier)
+	verified := time.Now()
+	s.phone = func(email string) (PhoneNumber, error) {
+		return PhoneNumber{Email: email, Number: "+14155550123", VerifiedAt: &verified}, nil
+	}
+	s.quota = func(email string) int { return quota }
+	count := func(match func(r SMSMessage) bool) int {
+		count := 0
+		for _, r := range records {
+			if r.Sent && match(r) {
+				count++
+			}
+		}
+		return count
+	}
+	s.sentSince = func(email string, since time.Time) (int, error) {
+		return count(func(r SMSMessage) bool { return r.Email == email }), nil
+	}
+	s.sentTo = func(number string, purpose string, since time.Time) (int, error) {
+		return count(func(r SMSMessage) bool { return r.Number == number && r.Purpose == purpose }), nil
+	}
+	s.sentTotal = func(since time.Time) (int, error) {
+		return count(func(r SMSMessage) bool { return true }), nil
+	}
+	s.record = func(m *SMSMessage) error { records = append(records, *m); return nil }
+	return s, &records
+}
+
+func TestSMSNotifier(t *testing.T) {
+	server, sent := fakeTwilio(t, http.StatusCreated, `{"sid":"SM1","status":"queued"}`)
+	defer server.Close()
+
+	n, records := testSMSNotifier(server.URL, 2)
+	assert.NoError(t, n.Send(testMessage()))
+	if assert.Len(t, *sent, 1) {
+		assert.Equal(t, "+14155550123", (*sent)[0]["To"])
+		assert.Equal(t, "+15005550006", (*sent)[0]["From"])
+		assert.Equal(t, "CryptoAlarms: BTC over 70k: BTC Current Price 70250.00 USD, Alert Level above 70000.00 USD", (*sent)[0]["Body"])
+	}
+
+	assert.NoError(t, n.Send(testMessage()))
+	assert.Equal(t, errSMSQuota, n.Send(testMessage()))
+	assert.Len(t, *sent, 2)
+	if assert.Len(t, *records, 2) {
+		assert.Equal(t, SMS_NOTIFICATION, (*records)[0].Purpose)
+		assert.True(t, (*records)[1].Sent)
+	}
+}
+
+func TestSMSNotifierLimits(t *testing.T) {
+	server, sent := fakeTwilio(t, http.StatusCreated, `{"sid":"SM1","status":"queued"}`)
+	defer server.Close()
+
+	// Verification codes to one number are capped across accounts.
+	n, _ := testSMSNotifier(server.URL, 10)
+	now := time.Now()
+	for i := 0; i < SMS_NUMBER_DAILY_CODES; i++ {
+		assert.NoError(t, n.send(fmt.Sprintf("user%d@example.com", i), "+14155550199", SMS_VERIFICATION, "code", now))
+	}
+	assert.Equal(t, errSMSNumberLimit, n.send("other@example.com", "+14155550199", SMS_VERIFICATION, "code", now))
+	assert.NoError(t, n.send("other@example.com", "+14155550100", SMS_VERIFICATION, "code", now))
+
+	// Every message counts against the daily limit for all users.
+	n.config.DailyLimit = SMS_NUMBER_DAILY_CODES + 2
+	assert.NoError(t, n.Send(testMessage()))
+	assert.Equal(t, errSMSDailyLimit, n.Send(Message{Email: "else@example.com"}))
+	assert.Len(t, *sent, SMS_NUMBER_DAILY_CODES+2)
+}
+
+func TestSMSNotifierErrors(t *testing.T) {
+	server, _ := fakeTwilio(t, http.StatusBadRequest, `{"code":21211,"message":"The 'To' number is not a valid phone number."}`)
+	defer server.Close()
+
+	n, records := testSMSNotifier(server.URL, 5)
+	assert.EqualError(t, n.Send(testMessage()), "twilio: The 'To' number is not a valid phone number. (code 21211)")
+	if assert.Len(t, *records, 1) {
+		assert.False(t, (*records)[0].Sent)
+		assert.Contains(t, (*records)[0].Error, "21211")
+	}
+
+	n.phone = func(email string) (PhoneNumber, error) { return PhoneNumber{Number: "+14155550123"}, nil }
+	assert.EqualError(t, n.Send(testMessage()), "user@example.com has no verified phone number")
+	n.phone = func(email string) (PhoneNumber, error) { return PhoneNumber{}, errors.New("record not found") }
+	assert.Error(t, n.Send(testMessage()))
+}
+
+func TestSMSText(t *testing.T) {
+	m := manyAlerts(6)
+	lines := strings.Split(smsText(m), "\n")
+	if assert.Len(t, lines, SMS_MAX_LINES+1) {
+		assert.Equal(t, "+2 more, see your email or dashboard", lines[SMS_MAX_LINES])
+	}
+
+	long := Notification{Kind: ALERT_KIND_COMPOSITE, CoinSymbol: "BTC", Metric: strings.Repeat("price_usd > 1 AND ", 10)}
+	line := smsLine("rule", long)
+	assert.Equal(t, SMS_LINE_MAX, len([]rune(line)))
+	assert.True(t, strings.HasSuffix(line, "..."))
+	assert.NotContains(t, line, "\n")
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	number, err := normalizePhoneNumber("+1 (415) 555-0123")
+	assert.NoError(t, err)
+	assert.Equal(t, "+14155550123", number)
+	for _, bad := range []string{"4155550123", "+0123456789", "+1415", "+1415555012345678", "+1415abc0123"} {
+		_, err := normalizePhoneNumber(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPhoneVerification(t *testing.T) {
+	now := time.Now()
+	var phone PhoneNumber
+	code, err := startPhoneVerification(&phone, "+44 20 7946 0958", now)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, code, VERIFICATION_CODE_DIGITS)
+	assert.Equal(t, "+442079460958", phone.Number)
+	assert.NotContains(t, phone.CodeHash, code)
+
+	_, err = startPhoneVerification(&phone, "+442079460958", now.Add(10*time.Second))
+	assert.Error(t, err, "resent too soon")
+	_, err = startPhoneVerification(&phone, "+14155550123", now.Add(10*time.Second))
+	assert.Error(t, err, "resent too soon to another number")
+	assert.Equal(t, "+442079460958", phone.Number)
+
+	assert.EqualError(t, checkPhoneVerification(&phone, "not it", now), "wrong verification code")
+	assert.Nil(t, phone.VerifiedAt)
+	assert.NoError(t, checkPhoneVerification(&phone, code, now.Add(time.Minute)))
+	assert.NotNil(t, phone.VerifiedAt)
+	assert.Error(t, checkPhoneVerification(&phone, code, now.Add(time.Minute)), "codes are single use")
+
+	// Changing the number needs a new verification.
+	code, _ = startPhoneVerification(&phone, "+14155550123", now.Add(2*time.Minute))
+	assert.Nil(t, phone.VerifiedAt)
+	assert.Error(t, checkPhoneVerification(&phone, code, now.Add(20*time.Minute)), "expired")
+
+	code, _ = startPhoneVerification(&phone, "+14155550123", now.Add(30*time.Minute))
+	for i := 0; i < VERIFICATION_MAX_ATTEMPTS; i++ {
+		checkPhoneVerification(&phone, "000000x", now.Add(31*time.Minute))
+	}
+	assert.EqualError(t, checkPhoneVerification(&phone, code, now.Add(31*time.Minute)), "too many attempts, request a new code")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ALERT_KIND_SPREAD compares one coin's USD price between two price
+// providers, SourceA and SourceB, and fires when the spread exceeds
+// Threshold percent in either direction. Quotes are shown in PriceCurrency.
+const ALERT_KIND_SPREAD = "spread"
+
+func init() {
+	registerAlertKind(ALERT_KIND_SPREAD, alertKind{validateSpreadAlert, evaluateSpreadAlert})
+}
+
+func validateSpreadAlert(alert *Alert) error {
+	if alert.SourceA == "" || alert.SourceB == "" {
+		return errors.New("spread alerts need source_a and source_b")
+	}
+	if alert.SourceA == alert.SourceB {
+		return errors.New("spread alerts need two different sources")
+	}
+	if alert.Threshold <= 0 {
+		return errors.New("threshold must be a positive spread percent")
+	}
+	if err := validateQuoteCurrency(&alert.PriceCurrency, false); err != nil {
+		return err
+	}
+	// Providers are only known once configured; alerts created before then
+	// are checked when they are evaluated.
+	if len(priceProviders) > 0 {
+		for _, source := range []string{alert.SourceA, alert.SourceB} {
+			if !isPriceProvider(source) {
+				return fmt.Errorf("unknown price source %q", source)
+			}
+		}
+	}
+	return nil
+}
+
+func isPriceProvider(name string) bool {
+	for _, p := range priceProviders {
+		if p.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sourcePrice returns one provider's quote and USD price for the alert's coin.
+func sourcePrice(alert Alert, source string, coinKey string, e evaluation) (CoinInfo, float64, error) {
+	prices, ok := e.SourcePrices[source]
+	if !ok {
+		log.Errorf("Skipping alert %d: source %s returned no prices", alert.ID, source)
+		return CoinInfo{}, 0, errStaleQuote
+	}
+	coinInfo, ok := prices[coinKey]
+	if !ok || isStaleQuote(coinInfo, fetchPolicy.MaxQuoteAge, e.Now) {
+		log.Errorf("Skipping alert %d: no current %s quote from %s", alert.ID, coinKey, source)
+		return coinInfo, 0, errStaleQuote
+	}
+	price, err := strconv.ParseFloat(coinInfo.PriceUSD, 64)
+	if err != nil || price <= 0 {
+		return coinInfo, 0, fmt.Errorf("no usable price for %s from %s", coinKey, source)
+	}
+	return coinInfo, price, nil
+}
+
+// evaluateSpreadAlert reads both prices straight from the sources. The
+// aggregated quote drops sources that stray from the median, so a wide
+// spread can leave the coin out of it entirely; it is only used for display.
+func evaluateSpreadAlert(alert Alert, e evaluation) (reading, error) {
+	coinMapKey, ok := e.CoinKeys[alert.CoinID]
+	if !ok {
+		coinMapKey = createCoinKey(alert.CoinSymbol, alert.CoinName)
+	}
+	quoteA, priceA, err := sourcePrice(alert, alert.SourceA, coinMapKey, e)
+	if err != nil {
+		return reading{}, err
+	}
+	_, priceB, err := sourcePrice(alert, alert.SourceB, coinMapKey, e)
+	if err != nil {
+		return reading{}, err
+	}
+	coinInfo, ok := e.CoinDeltas[coinMapKey]
+	if !ok {
+		coinInfo = quoteA
+	}
+
+	// The spread is signed: positive when SourceA quotes above SourceB.
+	spread := percentChange(priceB, priceA)
+	r := levelReading(math.Abs(spread), alert.Threshold, DIRECTION_ABOVE, alert.Hysteresis)
+	r.Value = spread
+	if r.Violation {
+		log.Debugf("Violation %s: %s %f vs %s %f (spread %.3f%%)",
+			alert.CoinSymbol, alert.SourceA, priceA, alert.SourceB, priceB, spread)
+	}
+
+	r.Notification = coinNotification(coinInfo)
+	r.Notification.Sources = alert.SourceA + "," + alert.SourceB
+	r.Notification.Metric = "spread"
+	r.Notification.CurrentValue = spread
+	r.Notification.ThresholdValue = alert.Threshold
+	currency, rate := e.FX.displayRate(alert.PriceCurrency)
+	r.Notification.Currency = currency
+	r.Notification.Detail = fmt.Sprintf("%s %s vs %s %s", alert.SourceA, formatPrice(priceA*rate, currency),
+		alert.SourceB, formatPrice(priceB*rate, currency))
+	setFXRate(&r.Notification, e.FX, rate)
+	return r, nil
+}
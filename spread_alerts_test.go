@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spreadEvaluation fetches the two recorded exchange tickers the way
+// runCoinTask does.
+func spreadEvaluation(t *testing.T) evaluation {
+	names := map[string]string{"BTC": "Bitcoin", "ETH": "Ethereum"}
+	a := fixtureServer(t, "exchange_a_tickers.json")
+	defer a.Close()
+	b := fixtureServer(t, "exchange_b_tickers.json")
+	defer b.Close()
+	pa, _ := newPriceProvider(ProviderConfig{Name: "exchange_a", Kind: "exchange", URL: a.URL, Names: names})
+	pb, _ := newPriceProvider(ProviderConfig{Name: "exchange_b", Kind: "exchange", URL: b.URL, Names: names})
+
+	sourcePrices, failures := fetchAllPrices([]PriceProvider{pa, pb})
+	assert.Empty(t, failures)
+	CoinDeltas := aggregatePrices(sourcePrices, aggregation)
+	return evaluation{CoinDeltas: CoinDeltas, CoinKeys: coinKeysByID(CoinDeltas),
+		Now: time.Unix(1709294460, 0), SourcePrices: sourcePrices}
+}
+
+func TestSpreadAlert(t *testing.T) {
+	e := spreadEvaluation(t)
+
+	btc := Alert{Kind: ALERT_KIND_SPREAD, CoinSymbol: "BTC", CoinName: "Bitcoin",
+		SourceA: "exchange_a", SourceB: "exchange_b", Threshold: 0.5}
+	r, err := evaluateAlert(btc, e)
+	if assert.NoError(t, err) {
+		assert.True(t, r.Violation)
+		assert.InDelta(t, -0.9938, r.Value, 1e-3)
+		assert.Equal(t, "exchange_a,exchange_b", r.Notification.Sources)
+		assert.Equal(t, "spread", r.Notification.Metric)
+	}
+
+	// Quotes are shown in the alert's currency; the spread itself is the same.
+	e.FX = FXRates{Rates: map[string]float64{"USD": 1, "EUR": 0.5}, Source: "test", AsOf: e.Now}
+	inEUR := btc
+	inEUR.PriceCurrency = "EUR"
+	r, err = evaluateAlert(inEUR, e)
+	if assert.NoError(t, err) {
+		assert.InDelta(t, -0.9938, r.Value, 1e-3)
+		assert.Equal(t, "EUR", r.Notification.Currency)
+		assert.Equal(t, 0.5, r.Notification.FXRate)
+		assert.Contains(t, r.Notification.Detail, " EUR vs exchange_b ")
+	}
+
+	eth := btc
+	eth.CoinSymbol, eth.CoinName = "ETH", "Ethereum"
+	r, err = evaluateAlert(eth, e)
+	if assert.NoError(t, err) {
+		assert.False(t, r.Violation)
+	}
+
+	// A source that has no quote for the coin skips the alert.
+	delete(e.SourcePrices["exchange_b"], "ETH_ETHEREUM")
+	_, err = evaluateAlert(eth, e)
+	assert.Equal(t, errStaleQuote, err)
+
+	// So does a stale quote.
+	e.Now = e.Now.Add(24 * time.Hour)
+	_, err = evaluateAlert(btc, e)
+	assert.Equal(t, errStaleQuote, err)
+}
+
+func TestSpreadAlertBeyondOutlierTolerance(t *testing.T) {
+	now := time.Unix(1709294460, 0)
+	quote := func(price string) CoinInfo {
+		return CoinInfo{Symbol: "SOL", Name: "Solana", PriceUSD: price, LastUpdated: "1709294400"}
+	}
+	sourcePrices := map[string]map[string]CoinInfo{
+		"exchange_a": {"SOL_SOLANA": quote("100")},
+		"exchange_b": {"SOL_SOLANA": quote("112")},
+	}
+	// Both sources stray more than the tolerance from the median, so the
+	// aggregated quotes leave the coin out.
+	CoinDeltas := aggregatePrices(sourcePrices, aggregation)
+	assert.NotContains(t, CoinDeltas, "SOL_SOLANA")
+
+	e := evaluation{CoinDeltas: CoinDeltas, CoinKeys: coinKeysByID(CoinDeltas), Now: now, SourcePrices: sourcePrices}
+	sol := Alert{Kind: ALERT_KIND_SPREAD, CoinSymbol: "SOL", CoinName: "Solana",
+		SourceA: "exchange_a", SourceB: "exchange_b", Threshold: 10}
+	r, err := evaluateAlert(sol, e)
+	if assert.NoError(t, err) {
+		assert.True(t, r.Violation)
+		assert.InDelta(t, -10.714, r.Value, 1e-3)
+		assert.Equal(t, "SOL", r.Notification.CoinSymbol)
+		assert.Equal(t, "exchange_a,exchange_b", r.Notification.Sources)
+	}
+}
+
+func TestValidateSpreadAlert(t *testing.T) {
+	alert := Alert{Kind: ALERT_KIND_SPREAD, CoinSymbol: "BTC", SourceA: "a", SourceB: "a", Threshold: 1}
+	assert.Error(t, validateAlert(&alert))
+	alert.SourceB = ""
+	assert.Error(t, validateAlert(&alert))
+	alert.SourceB = "b"
+	assert.NoError(t, validateAlert(&alert))
+	alert.Threshold = 0
+	assert.Error(t, validateAlert(&alert))
+
+	p, _ := newPriceProvider(ProviderConfig{Name: "a", Kind: "file"})
+	priceProviders = []PriceProvider{p}
+	defer func() { priceProviders = nil }()
+	alert.Threshold = 1
+	assert.Error(t, validateAlert(&alert))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/cbonoz/crypto-go/indicators"
+)
+
+// ALERT_KIND_VOLATILITY fires when a coin's move over TimeDelta is larger
+// than Threshold standard deviations of its TimeDelta returns over Lookback,
+// so the threshold adapts to how volatile each coin normally is.
+const ALERT_KIND_VOLATILITY = "volatility"
+
+const (
+	DEFAULT_VOLATILITY_INTERVAL = "1h"
+	DEFAULT_VOLATILITY_LOOKBACK = "30d"
+	// Minimum returns in the lookback before the deviation is trusted.
+	MIN_VOLATILITY_SAMPLES = 24
+)
+
+func init() {
+	registerAlertKind(ALERT_KIND_VOLATILITY, alertKind{validateVolatilityAlert, evaluateVolatilityAlert})
+}
+
+// validateVolatilityAlert checks a volatility alert. Direction is "above"
+// for unusual rises, "below" for unusual drops, or empty for either.
+func validateVolatilityAlert(alert *Alert) error {
+	if alert.Threshold <= 0 {
+		return errors.New("threshold must be a positive number of standard deviations")
+	}
+	alert.Direction = strings.ToLower(alert.Direction)
+	if alert.Direction != "" && alert.Direction != DIRECTION_ABOVE && alert.Direction != DIRECTION_BELOW {
+		return fmt.Errorf("direction must be %q, %q or empty for either", DIRECTION_ABOVE, DIRECTION_BELOW)
+	}
+	if alert.TimeDelta == "" {
+		alert.TimeDelta = DEFAULT_VOLATILITY_INTERVAL
+	}
+	if alert.Lookback == "" {
+		alert.Lookback = DEFAULT_VOLATILITY_LOOKBACK
+	}
+	interval, err := parseTimeDelta(alert.TimeDelta)
+	if err != nil {
+		return err
+	}
+	if interval < MIN_INDICATOR_INTERVAL {
+		return fmt.Errorf("time_delta must be at least %s", MIN_INDICATOR_INTERVAL)
+	}
+	lookback, err := parseTimeDelta(alert.Lookback)
+	if err != nil {
+		return fmt.Errorf("invalid lookback: %s", err.Error())
+	}
+	if lookback < interval*(MIN_VOLATILITY_SAMPLES+1) {
+		return fmt.Errorf("lookback must cover at least %d returns of %s", MIN_VOLATILITY_SAMPLES, alert.TimeDelta)
+	}
+	return nil
+}
+
+// returnDeviation returns the mean and standard deviation of a coin's percent
+// returns per interval over lookback, ending at now.
+func returnDeviation(coinKey string, interval time.Duration, lookback time.Duration, now time.Time) (float64, float64, error) {
+	closes, err := closingPrices(coinKey, interval, int(lookback/interval), now)
+	if err != nil {
+		return 0, 0, err
+	}
+	returns, err := indicators.Returns(closes)
+	if err != nil || len(returns) < MIN_VOLATILITY_SAMPLES {
+		return 0, 0, fmt.Errorf("not enough price history for %s (%d returns)", coinKey, len(returns))
+	}
+	return indicators.MeanStdDev(returns)
+}
+
+func evaluateVolatilityAlert(alert Alert, e evaluation) (reading, error) {
+	coinMapKey, coinInfo, err := lookupCoin(alert, e)
+	if err != nil {
+		return reading{}, err
+	}
+	interval, err := parseTimeDelta(alert.TimeDelta)
+	if err != nil {
+		return reading{}, err
+	}
+	lookback, err := parseTimeDelta(alert.Lookback)
+	if err != nil {
+		return reading{}, err
+	}
+
+	// Measure the deviation up to the start of the current move so the move
+	// does not inflate its own baseline.
+	mean, deviation, err := returnDeviation(coinMapKey, interval, lookback, e.Now.Add(-interval))
+	if err != nil {
+		return reading{}, err
+	}
+	if deviation == 0 {
+		return reading{}, fmt.Errorf("%s price has not moved over %s", coinMapKey, alert.Lookback)
+	}
+	move, err := coinChange(coinMapKey, coinInfo, alert.TimeDelta, e.Now)
+	if err != nil {
+		return reading{}, fmt.Errorf("could not compute %s change: %s", alert.TimeDelta, err.Error())
+	}
+	z := (move - mean) / deviation
+
+	// Without a direction, judge the size of the move either way.
+	value, direction := z, alert.Direction
+	if direction == "" {
+		value, direction = math.Abs(z), DIRECTION_ABOVE
+	}
+	level := alert.Threshold
+	if direction == DIRECTION_BELOW {
+		level = -alert.Threshold
+	}
+	r := levelReading(value, level, direction, alert.Hysteresis)
+	r.Value = z
+	if r.Violation {
+		log.Debugf("Violation %s: %s move %.2f%% is %.2f sigma (sigma %.4f%%, threshold %.2f)",
+			alert.CoinSymbol, alert.TimeDelta, move, z, deviation, alert.Threshold)
+	}
+
+	r.Notification = coinNotification(coinInfo)
+	r.Notification.Metric = "zscore"
+	r.Notification.TimeDelta = alert.TimeDelta
+	r.Notification.Direction = alert.Direction
+	r.Notification.CurrentDelta = move
+	r.Notification.ThresholdDelta = mean + level*deviation
+	r.Notification.CurrentValue = z
+	r.Notification.ThresholdValue = alert.Threshold
+	r.Notification.Baseline = deviation
+	r.Notification.Detail = fmt.Sprintf("%s of %s returns", alert.Lookback, alert.TimeDelta)
+	return r, nil
+}
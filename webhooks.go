@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/levigross/grequests"
+)
+
+// Version of the webhook payload format, bumped on incompatible changes.
+const WEBHOOK_PAYLOAD_VERSION = "1"
+
+// Headers sent with every webhook request. The signature is the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the endpoint's secret.
+const (
+	WEBHOOK_SIGNATURE_HEADER = "X-CryptoAlarms-Signature"
+	WEBHOOK_TIMESTAMP_HEADER = "X-CryptoAlarms-Timestamp"
+	WEBHOOK_DELIVERY_HEADER  = "X-CryptoAlarms-Delivery"
+)
+
+// Deliveries listed per webhook by the API.
+const WEBHOOK_DELIVERIES_LIMIT = 100
+
+// webhookEvent is one notification in a webhook payload.
+type webhookEvent struct {
+	NotificationID  uint    `json:"notification_id"` // unique per event, for receivers that drop duplicates.
+	AlertID         uint    `json:"alert_id"`
+	AlertName       string  `json:"alert_name"`
+	Kind            string  `json:"kind"`
+	CoinSymbol      string  `json:"coin_symbol"`
+	CoinName        string  `json:"coin_name"`
+	Metric          string  `json:"metric,omitempty"`
+	Direction       string  `json:"direction,omitempty"`
+	CurrentValue    float64 `json:"current_value"`
+	ThresholdValue  float64 `json:"threshold_value"`
+	CurrentDelta    float64 `json:"current_delta"`
+	ThresholdDelta  float64 `json:"threshold_delta"`
+	TimeDelta       string  `json:"time_delta,omitempty"`
+	Currency        string  `json:"currency,omitempty"`
+	Detail          string  `json:"detail,omitempty"`
+	Sources         string  `json:"sources,omitempty"`
+	CooldownMinutes int     `json:"cooldown_minutes"`
+	LastUpdated     int64   `json:"last_updated"` // unix seconds of the quote.
+}
+
+// webhookPayload is the JSON body posted to webhooks. ID is derived from the
+// notifications it carries, so it stays the same when the outbox retries
+// them. A webhook is never sent a notification it already received.
+type webhookPayload struct {
+	Version string         `json:"version"`
+	ID      string         `json:"id"`
+	Email   string         `json:"email"`
+	SentAt  int64          `json:"sent_at"`
+	Events  []webhookEvent `json:"events"`
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// webhookPayloadID identifies a message by the IDs of its notifications.
+func webhookPayloadID(m Message) string {
+	ids := make([]string, len(m.Notifications))
+	for i, n := range m.Notifications {
+		ids[i] = strconv.FormatUint(uint64(n.ID), 10)
+	}
+	sort.Strings(ids)
+	sum := sha256.Sum256([]byte(m.Email + ":" + strings.Join(ids, ",")))
+	return hex.EncodeToString(sum[:16])
+}
+
+func newWebhookPayload(m Message, now time.Time) webhookPayload {
+	payload := webhookPayload{Version: WEBHOOK_PAYLOAD_VERSION, ID: webhookPayloadID(m), Email: m.Email, SentAt: now.Unix()}
+	for i, n := range m.Notifications {
+		kind := n.Kind
+		if kind == "" {
+			kind = ALERT_KIND_CHANGE
+		}
+		payload.Events = append(payload.Events, webhookEvent{
+			NotificationID: n.ID, AlertID: n.AlertId, AlertName: m.AlertNames[i], Kind: kind,
+			CoinSymbol: n.CoinSymbol, CoinName: n.CoinName, Metric: n.Metric, Direction: n.Direction,
+			CurrentValue: n.CurrentValue, ThresholdValue: n.ThresholdValue,
+			CurrentDelta: n.CurrentDelta, ThresholdDelta: n.ThresholdDelta, TimeDelta: n.TimeDelta,
+			Currency: n.Currency, Detail: n.Detail, Sources: n.Sources,
+			CooldownMinutes: n.CooldownMinutes, LastUpdated: n.LastUpdated,
+		})
+	}
+	return payload
+}
+
+// signWebhook returns the signature header value for body sent at timestamp.
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateWebhook checks a webhook registration and generates its secret.
+func validateWebhook(hook *Webhook) error {
+	if hook.Email == "" {
+		return errors.New("missing email")
+	}
+	u, err := neturl.Parse(hook.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q", hook.URL)
+	}
+	hook.Secret = randomHex(32)
+	hook.Active = true
+	return nil
+}
+
+// activeWebhooks returns the webhooks a user registered.
+func activeWebhooks(email string) ([]Webhook, error) {
+	var hooks []Webhook
+	err := db.Where("email = ? AND active = ?", email, true).Order("id").Find(&hooks).Error
+	return hooks, err
+}
+
+// payloadDeliveries returns the attempts made so far to deliver a payload.
+func payloadDeliveries(payloadID string) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Where("payload_id = ?", payloadID).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func recordWebhookDelivery(d *WebhookDelivery) error {
+	return db.Create(d).Error
+}
+
+// webhookNotifier posts a signed, versioned JSON payload to every webhook the
+// user registered. Each Send makes one attempt per webhook; the outbox
+// retries failures, and each webhook is only sent the notifications it has
+// not received yet.
+type webhookNotifier struct {
+	config     NotifierConfig
+	endpoints  func(email string) ([]Webhook, error)
+	receipts   deliveryReceipts
+	deliveries func(payloadID string) ([]WebhookDelivery, error)
+	record     func(d *WebhookDelivery) error
+}
+
+func newWebhookNotifier(config NotifierConfig) *webhookNotifier {
+	return &webhookNotifier{config, activeWebhooks, storedReceipts, payloadDeliveries, recordWebhookDelivery}
+}
+
+func (n *webhookNotifier) Name() string {
+	return n.config.Name
+}
+
+func (n *webhookNotifier) Send(m Message) error {
+	hooks, err := n.endpoints(m.Email)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return fmt.Errorf("%s has no active webhooks", m.Email)
+	}
+	var failed []string
+	for _, hook := range hooks {
+		err := n.receipts.sendMissing(n.config.Name, hook.ID, m, func(part Message) error {
+			return n.deliver(hook, part)
+		})
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("webhook %d: %s", hook.ID, err.Error()))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// deliver posts m to one webhook and records the attempt.
+func (n *webhookNotifier) deliver(hook Webhook, m Message) error {
+	payload := newWebhookPayload(m, time.Now())
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	previous, err := n.deliveries(payload.ID)
+	if err != nil {
+		return err
+	}
+	d := WebhookDelivery{WebhookID: hook.ID, PayloadID: payload.ID, Attempt: 1}
+	for _, p := range previous {
+		if p.WebhookID == hook.ID {
+			d.Attempt++
+		}
+	}
+	err = n.post(hook, payload.ID, body, &d)
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if recordErr := n.record(&d); recordErr != nil {
+		log.Errorf("could not record delivery to webhook %d: %s", hook.ID, recordErr.Error())
+	}
+	return err
+}
+
+// post makes a single signed request, filling in the delivery's outcome.
+func (n *webhookNotifier) post(hook Webhook, payloadID string, body []byte, d *WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	start := time.Now()
+	resp, err := grequests.Post(hook.URL, &grequests.RequestOptions{
+		JSON: body, RequestTimeout: NOTIFY_TIMEOUT,
+		Headers: map[string]string{
+			WEBHOOK_SIGNATURE_HEADER: signWebhook(hook.Secret, timestamp, body),
+			WEBHOOK_TIMESTAMP_HEADER: timestamp,
+			WEBHOOK_DELIVERY_HEADER:  payloadID,
+		},
+	})
+	d.DurationMs = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		if urlErr, ok := err.(*neturl.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	d.StatusCode = resp.StatusCode
+	if !resp.Ok {
+		return fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+	d.Delivered = true
+	return nil
+}
+
+// webhookDeliveries returns the latest delivery attempts to a webhook.
+func webhookDeliveries(webhookID uint) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Where("webhook_id = ?", webhookID).Order("id desc").Limit(WEBHOOK_DELIVERIES_LIMIT).
+		Find(&deliveries).Error
+	return deliveries, err
+}
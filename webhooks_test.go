@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testWebhookNotifier delivers to hooks, keeping delivery records in memory.
+func testWebhookNotifier(hooks ...Webhook) (*webhookNotifier, *[]WebhookDelivery) {
+	var deliveries []WebhookDelivery
+	n := &webhookNotifier{
+		config:    NotifierConfig{Name: "webhook", Kind: "webhook"},
+		endpoints: func(email string) ([]Webhook, error) { return hooks, nil },
+		receipts:  memoryReceipts(),
+		deliveries: func(payloadID string) ([]WebhookDelivery, error) {
+			var matching []WebhookDelivery
+			for _, d := range deliveries {
+				if d.PayloadID == payloadID {
+					matching = append(matching, d)
+				}
+			}
+			return matching, nil
+		},
+		record: func(d *WebhookDelivery) error { deliveries = append(deliveries, *d); return nil },
+	}
+	return n, &deliveries
+}
+
+func TestWebhookNotifierSignsPayload(t *testing.T) {
+	var payload webhookPayload
+	var signature, timestamp, delivery string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(WEBHOOK_SIGNATURE_HEADER)
+		timestamp = r.Header.Get(WEBHOOK_TIMESTAMP_HEADER)
+		delivery = r.Header.Get(WEBHOOK_DELIVERY_HEADER)
+		json.Unmarshal(body, &payload)
+	}))
+	defer server.Close()
+
+	hook := Webhook{URL: server.URL, Secret: "s3cret", Active: true}
+	hook.ID = 7
+	n, deliveries := testWebhookNotifier(hook)
+	m := testMessage()
+	m.Notifications[0].AlertId = 42
+	if !assert.NoError(t, n.Send(m)) {
+		return
+	}
+
+	assert.Equal(t, signWebhook("s3cret", timestamp, body), signature)
+	assert.NotEqual(t, signWebhook("other", timestamp, body), signature)
+	assert.Equal(t, payload.ID, delivery)
+	assert.Equal(t, WEBHOOK_PAYLOAD_VERSION, payload.Version)
+	assert.Equal(t, "user@example.com", payload.Email)
+	if assert.Len(t, payload.Events, 1) {
+		assert.Equal(t, uint(42), payload.Events[0].AlertID)
+		assert.Equal(t, "BTC over 70k", payload.Events[0].AlertName)
+		assert.Equal(t, ALERT_KIND_PRICE, payload.Events[0].Kind)
+		assert.Equal(t, 70250.0, payload.Events[0].CurrentValue)
+	}
+	if assert.Len(t, *deliveries, 1) {
+		d := (*deliveries)[0]
+		assert.True(t, d.Delivered)
+		assert.Equal(t, uint(7), d.WebhookID)
+		assert.Equal(t, 200, d.StatusCode)
+		assert.Equal(t, 1, d.Attempt)
+	}
+}
+
+func TestWebhookNotifierResendsToFailedHooks(t *testing.T) {
+	var okIDs, flakyIDs []string
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		okIDs = append(okIDs, r.Header.Get(WEBHOOK_DELIVERY_HEADER))
+	}))
+	defer ok.Close()
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flakyIDs = append(flakyIDs, r.Header.Get(WEBHOOK_DELIVERY_HEADER))
+		if len(flakyIDs) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer flaky.Close()
+
+	first, second := Webhook{URL: ok.URL, Secret: "s"}, Webhook{URL: flaky.URL, Secret: "s"}
+	first.ID, second.ID = 1, 2
+	n, deliveries := testWebhookNotifier(first, second)
+	err := n.Send(testMessage())
+	if assert.Error(t, err) {
+		assert.Equal(t, "webhook 2: returned status 502", err.Error())
+	}
+	if assert.Len(t, *deliveries, 2) {
+		assert.True(t, (*deliveries)[0].Delivered)
+		assert.Equal(t, 502, (*deliveries)[1].StatusCode)
+		assert.False(t, (*deliveries)[1].Delivered)
+		assert.Equal(t, "returned status 502", (*deliveries)[1].Error)
+	}
+
+	// The outbox resends the same notifications: only the failed hook is
+	// posted to again, under the same payload ID.
+	assert.NoError(t, n.Send(testMessage()))
+	assert.Len(t, okIDs, 1)
+	if assert.Len(t, flakyIDs, 2) {
+		assert.Equal(t, okIDs[0], flakyIDs[1])
+	}
+	if assert.Len(t, *deliveries, 3) {
+		assert.True(t, (*deliveries)[2].Delivered)
+		assert.Equal(t, 2, (*deliveries)[2].Attempt)
+	}
+	assert.NoError(t, n.Send(testMessage()))
+	assert.Len(t, *deliveries, 3)
+
+	// A retry batched with a new notification sends each hook only what it
+	// has not received.
+	m := manyAlerts(2)
+	m.Notifications[1].ID = 9
+	assert.NoError(t, n.Send(m))
+	if assert.Len(t, okIDs, 2) && assert.Len(t, flakyIDs, 3) {
+		assert.NotEqual(t, okIDs[0], okIDs[1])
+		assert.Equal(t, okIDs[1], flakyIDs[2])
+	}
+	assert.Len(t, *deliveries, 5)
+}
+
+func TestWebhookNotifierMakesOneAttempt(t *testing.T) {
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer gone.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	n, deliveries := testWebhookNotifier(Webhook{URL: gone.URL}, Webhook{URL: down.URL})
+	err := n.Send(testMessage())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "410")
+		assert.Contains(t, err.Error(), "503")
+	}
+	assert.Len(t, *deliveries, 2)
+
+	n, _ = testWebhookNotifier()
+	assert.Error(t, n.Send(testMessage()))
+}
+
+func TestWebhookPayloadID(t *testing.T) {
+	m := manyAlerts(3)
+	for i := range m.Notifications {
+		m.Notifications[i].ID = uint(10 + i)
+	}
+	id := webhookPayloadID(m)
+	assert.Len(t, id, 32)
+	assert.Equal(t, id, newWebhookPayload(m, time.Now()).ID)
+	assert.Equal(t, id, newWebhookPayload(m, time.Now().Add(time.Hour)).ID)
+
+	m.Notifications[0], m.Notifications[2] = m.Notifications[2], m.Notifications[0]
+	assert.Equal(t, id, webhookPayloadID(m), "independent of order")
+	m.Notifications = m.Notifications[1:]
+	assert.NotEqual(t, id, webhookPayloadID(m))
+}
+
+func TestValidateWebhook(t *testing.T) {
+	hook := Webhook{Email: "user@example.com", URL: "ftp://example.com/hook"}
+	assert.Error(t, validateWebhook(&hook))
+	hook.URL = "https:///hook"
+	assert.Error(t, validateWebhook(&hook))
+	hook.URL = "https://bots.example.com/alerts"
+	if assert.NoError(t, validateWebhook(&hook)) {
+		assert.Len(t, hook.Secret, 64)
+		assert.True(t, hook.Active)
+	}
+	hook.Email = ""
+	assert.Error(t, validateWebhook(&hook))
+}
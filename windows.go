@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var timeDeltaPattern = regexp.MustCompile(`^(\d+)(m|h|d|w)$`)
+
+const MAX_TIME_DELTA = 365 * 24 * time.Hour
+
+// A historical snapshot may lag the start of the window by at most this much
+// before the change is considered unknown.
+const HISTORY_MAX_GAP = time.Hour
+
+// Shorter windows allow a proportionally smaller lag, so the change is never
+// measured over much more than the window itself.
+const HISTORY_MAX_GAP_FRACTION = 0.5
+
+// Snapshots are only taken once per scheduler tick, so the lag allowed is
+// never less than a tick plus this much for fetch latency.
+const HISTORY_GAP_SLACK = 5 * time.Minute
+
+var timeDeltaUnits = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// parseTimeDelta parses alert windows such as "15m", "4h", "3d" or "2w".
+func parseTimeDelta(timeDelta string) (time.Duration, error) {
+	match := timeDeltaPattern.FindStringSubmatch(timeDelta)
+	if match == nil {
+		return 0, fmt.Errorf("invalid time_delta %q: expected a number followed by m, h, d or w (e.g. 15m, 4h, 3d)", timeDelta)
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid time_delta %q: window must be positive", timeDelta)
+	}
+	window := time.Duration(n) * timeDeltaUnits[match[2]]
+	if window > MAX_TIME_DELTA {
+		return 0, fmt.Errorf("invalid time_delta %q: window cannot exceed 365d", timeDelta)
+	}
+	return window, nil
+}
+
+// providerChange returns the provider-supplied percent change for windows
+// the ticker reports directly.
+func providerChange(coinInfo CoinInfo, window time.Duration) (string, bool) {
+	var change string
+	switch window {
+	case time.Hour:
+		change = coinInfo.Change1h
+	case 24 * time.Hour:
+		change = coinInfo.Change24h
+	case 7 * 24 * time.Hour:
+		change = coinInfo.Change7d
+	}
+	return change, change != ""
+}
+
+// historyMaxGap is how far a snapshot may lag the start of a window.
+func historyMaxGap(window time.Duration) time.Duration {
+	gap := time.Duration(float64(window) * HISTORY_MAX_GAP_FRACTION)
+	if tick := COIN_TASK_INTERVAL*time.Minute + HISTORY_GAP_SLACK; gap < tick {
+		gap = tick
+	}
+	if gap > HISTORY_MAX_GAP {
+		return HISTORY_MAX_GAP
+	}
+	return gap
+}
+
+// snapshotAt returns the latest stored snapshot for the coin taken at or
+// before t, and no more than maxGap before it.
+func snapshotAt(coinKey string, t time.Time, maxGap time.Duration) (PriceSnapshot, error) {
+	var snapshot PriceSnapshot
+	err := db.Where("coin_key = ? AND taken_at <= ?", coinKey, t).
+		Order("taken_at desc").First(&snapshot).Error
+	if err != nil {
+		return snapshot, err
+	}
+	return snapshot, checkSnapshotGap(snapshot, t, maxGap)
+}
+
+// checkSnapshotGap returns an error if snapshot was taken more than maxGap
+// before t.
+func checkSnapshotGap(snapshot PriceSnapshot, t time.Time, maxGap time.Duration) error {
+	if t.Sub(snapshot.TakenAt) > maxGap {
+		return fmt.Errorf("no snapshot for %s near %s (closest %s)", snapshot.CoinKey, t, snapshot.TakenAt)
+	}
+	return nil
+}
+
+func percentChange(from float64, to float64) float64 {
+	return (to - from) / from * 100
+}
+
+// historicalChange computes the percent change in USD price over window from
+// stored snapshots.
+func historicalChange(coinKey string, currentPrice float64, window time.Duration, now time.Time) (float64, error) {
+	snapshot, err := snapshotAt(coinKey, now.Add(-window), historyMaxGap(window))
+	if err != nil {
+		return 0, err
+	}
+	if snapshot.PriceUSD <= 0 {
+		return 0, fmt.Errorf("no usable price for %s at %s", coinKey, snapshot.TakenAt)
+	}
+	return percentChange(snapshot.PriceUSD, currentPrice), nil
+}
+
+// coinChange returns the percent change of a coin over the alert window,
+// using the provider's own figure when it reports that exact window.
+func coinChange(coinKey string, coinInfo CoinInfo, timeDelta string, now time.Time) (float64, error) {
+	window, err := parseTimeDelta(timeDelta)
+	if err != nil {
+		return 0, err
+	}
+	if change, ok := providerChange(coinInfo, window); ok {
+		return strconv.ParseFloat(change, 64)
+	}
+	currentPrice, err := strconv.ParseFloat(coinInfo.PriceUSD, 64)
+	if err != nil {
+		return 0, err
+	}
+	return historicalChange(coinKey, currentPrice, window, now)
+}
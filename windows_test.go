@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeDelta(t *testing.T) {
+	valid := map[string]time.Duration{
+		"15m": 15 * time.Minute,
+		"4h":  4 * time.Hour,
+		"24h": 24 * time.Hour,
+		"3d":  72 * time.Hour,
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+	}
+	for timeDelta, expected := range valid {
+		window, err := parseTimeDelta(timeDelta)
+		if assert.NoError(t, err, timeDelta) {
+			assert.Equal(t, expected, window, timeDelta)
+		}
+	}
+
+	for _, timeDelta := range []string{"", "1", "h", "0h", "-1h", "1y", "1.5h", "400d"} {
+		_, err := parseTimeDelta(timeDelta)
+		assert.Error(t, err, timeDelta)
+	}
+}
+
+func TestCoinChangeUsesProviderFields(t *testing.T) {
+	coinInfo := CoinInfo{PriceUSD: "100", Change1h: "1.5", Change24h: "-3", Change7d: "12"}
+
+	change, err := coinChange("BTC_BITCOIN", coinInfo, "1d", time.Now())
+	if assert.NoError(t, err) {
+		assert.Equal(t, -3.0, change)
+	}
+	change, err = coinChange("BTC_BITCOIN", coinInfo, "7d", time.Now())
+	if assert.NoError(t, err) {
+		assert.Equal(t, 12.0, change)
+	}
+}
+
+func TestHistoryMaxGap(t *testing.T) {
+	tick := COIN_TASK_INTERVAL*time.Minute + HISTORY_GAP_SLACK
+	assert.Equal(t, tick, historyMaxGap(15*time.Minute))
+	assert.Equal(t, tick, historyMaxGap(30*time.Minute))
+	assert.Equal(t, 45*time.Minute, historyMaxGap(90*time.Minute))
+	assert.Equal(t, HISTORY_MAX_GAP, historyMaxGap(4*time.Hour))
+	assert.Equal(t, HISTORY_MAX_GAP, historyMaxGap(30*24*time.Hour))
+}
+
+func TestShortWindowsOnSchedulerGrid(t *testing.T) {
+	// Snapshots land once per tick, a little after it depending on fetch latency.
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	latency := []time.Duration{0, 40 * time.Second, 3 * time.Minute, 10 * time.Second, 2 * time.Minute}
+	var grid []PriceSnapshot
+	for i, l := range latency {
+		taken := start.Add(time.Duration(i)*COIN_TASK_INTERVAL*time.Minute + l)
+		grid = append(grid, PriceSnapshot{CoinKey: "BTC_BITCOIN", TakenAt: taken})
+	}
+
+	now := grid[len(grid)-1].TakenAt
+	for _, window := range []time.Duration{15 * time.Minute, 30 * time.Minute} {
+		at := now.Add(-window)
+		var closest PriceSnapshot
+		for _, snapshot := range grid {
+			if !snapshot.TakenAt.After(at) {
+				closest = snapshot
+			}
+		}
+		assert.NoError(t, checkSnapshotGap(closest, at, historyMaxGap(window)), window.String())
+	}
+	assert.Error(t, checkSnapshotGap(grid[0], now.Add(-15*time.Minute), historyMaxGap(15*time.Minute)))
+}